@@ -1,6 +1,12 @@
 package main
 
-import "github.com/spf13/cobra"
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
 
 func init() {
 	// User does normal coding in the main project. User wants to change the code of the main repo, adding and removing some imports, then build
@@ -11,22 +17,159 @@ func init() {
 	// (use-cases.md 6.1)
 	cmd := &cobra.Command{
 		Use:   "check",
-		Short: "[TODO][NIY] for use as a git pre-commit hook",
+		Short: "for use as a git pre-commit hook",
+		Long: fmt.Sprintf(
+			`Check verifies that contents of %s/ and %s in git's staging area are
+consistent with each other and with the project's imports, that vendored
+repositories are not patched without a note in %s, and that their licenses
+are allowed. All problems found are reported.
+
+Exit code is 0 if no errors were found, otherwise a bitwise OR of:
+  %2d  a check could not be completed
+  %2d  consistency of %s/ and %s
+  %2d  dependencies of the project
+  %2d  local patches
+  %2d  licenses
+
+With --fast, only the *.go files changed in git's staging area are analyzed
+first, and the checks are skipped if their imports are the same as in HEAD,
+and %s/ and %s are not changed. If only imports changed, only the
+dependencies are checked.
+
+With --range A..B, the checks are run for each commit in the range, as if it
+was being committed on top of its parent. This is intended for pre-push hooks
+and CI, to catch commits made with 'git commit --no-verify'.`,
+			VendorPath, JsonPath, JsonPath,
+			ExitInternal, ExitConsistency, VendorPath, JsonPath, ExitDependencies, ExitPatched, ExitLicenses,
+			VendorPath, JsonPath),
 	}
-	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
-		err := CheckConsistency()
-		if err != nil {
-			return err
+	format := cmd.Flags().String("format", "text", "output format: text, json or junit")
+	fast := cmd.Flags().Bool("fast", false, "skip checks if staged changes don't touch imports, "+JsonPath+" nor "+VendorPath+"/")
+	revRange := cmd.Flags().String("range", "", "check each commit in git revision range (e.g. origin/master..HEAD) instead of staging area")
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		if *format != "text" && *format != "json" && *format != "junit" {
+			// TODO(mateuszc): subcmd usage
+			fmt.Fprintf(os.Stderr, "error: unknown format %q, expected: text, json or junit\n", *format)
+			Exit(ExitInternal)
 		}
-		err = CheckDependencies()
-		if err != nil {
-			return err
+		if *revRange != "" {
+			if *fast {
+				fmt.Fprintln(os.Stderr, "error: flags --fast and --range cannot be used together")
+				Exit(ExitInternal)
+			}
+			code, err := checkRange(*revRange, *format)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				Exit(ExitInternal)
+			}
+			Exit(code)
+		}
+		findings, checks := Check(*fast)
+		var err error
+		switch *format {
+		case "text":
+			err = findings.WriteText(os.Stdout)
+		case "json":
+			err = findings.WriteJson(os.Stdout)
+		case "junit":
+			err = findings.WriteJunit(os.Stdout, checks)
 		}
-		err = CheckPatched()
 		if err != nil {
-			return err
+			fmt.Fprintln(os.Stderr, "error:", err)
+			Exit(ExitInternal)
 		}
-		return nil
-	})
+		Exit(findings.ExitCode())
+	}
 	cmds.AddCommand(cmd)
 }
+
+// Check runs all the checks, and collects their findings. If a check could
+// not be completed, its error is reported as a finding of check CheckIdInternal.
+// It returns also IDs of the checks which were run. If fast is true, checks
+// not affected by staged changes are skipped (see findStagedChanges).
+func Check(fast bool) (Findings, []string) {
+	checks := []struct {
+		id  string
+		run func() (Findings, error)
+	}{
+		{CheckIdConsistency, CheckConsistency},
+		{CheckIdDependencies, CheckDependencies},
+		{CheckIdPatched, CheckPatched},
+		{CheckIdLicenses, CheckLicenses},
+	}
+	all := Findings{}
+	ids := []string{}
+	internal := false
+	only := ""
+	if fast {
+		// (use-cases.md 6.1.1.1)
+		changes, err := findStagedChanges()
+		switch {
+		case err != nil:
+			all.Add(CheckIdInternal, "cannot analyze staged changes: %s", err)
+			return all, []string{CheckIdInternal}
+		case changes.Vendor:
+			// Run all checks.
+		case len(changes.Imports) > 0:
+			fmt.Fprintf(os.Stderr, "# imports changed in: %s\n", strings.Join(changes.Imports, " "))
+			only = CheckIdDependencies
+		default:
+			fmt.Fprintf(os.Stderr, "# no changes to imports, %s nor %s/; skipping checks\n", JsonPath, VendorPath)
+			return all, ids
+		}
+	}
+	for _, check := range checks {
+		if only != "" && check.id != only {
+			continue
+		}
+		ids = append(ids, check.id)
+		endPhase := Phase("check " + check.id)
+		findings, err := check.run()
+		endPhase()
+		all = append(all, findings...)
+		if err != nil {
+			all.Add(CheckIdInternal, "check %q could not be completed: %s", check.id, err)
+			internal = true
+		}
+	}
+	if internal {
+		ids = append(ids, CheckIdInternal)
+	}
+	return all, ids
+}
+
+// checkRange runs CheckRange for project in current directory, writes the
+// results in format, and returns the exit code.
+func checkRange(revRange, format string) (int, error) {
+	exist := Exist{}.GitDir(".git")
+	if exist.Err != nil {
+		return 0, exist.Err
+	}
+	importPath, err := findProjectImportPath()
+	if err != nil {
+		return 0, err
+	}
+	repoDir, err := os.Getwd()
+	if err != nil {
+		return 0, err
+	}
+	results, err := CheckRange(repoDir, importPath, revRange)
+	if err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, fmt.Errorf("no commits in range: %s", revRange)
+	}
+	switch format {
+	case "text":
+		err = results.WriteText(os.Stdout)
+	case "json":
+		err = results.WriteJson(os.Stdout)
+	case "junit":
+		err = results.WriteJunit(os.Stdout)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return results.ExitCode(), nil
+}
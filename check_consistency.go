@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -12,12 +14,14 @@ import (
 //	- all "repositoryRoot" directories listed in vendor.json exist in _vendor/;
 //	- all the directories in _vendor/ belong to some "repositoryRoot";
 //	- there are no stray files outside of repository roots in _vendor/ (other
-//	  than _vendor/.gitignore).
+//	  than _vendor/.gitignore);
+//	- there are no git submodules ("gitlinks") in _vendor/.
 //
 // It doesn't check contents (files & dirs) of the repository roots - this is
-// responsibility of func CheckPatched().
+// responsibility of func CheckPatched(). Problems are returned as findings; an
+// error is returned only if the check could not be completed.
 // (use-cases.md 6.1.2.1)
-func CheckConsistency() error {
+func CheckConsistency() (Findings, error) {
 
 	// NOTE: this function operates strictly on files in git's "staging area" (index).
 	// ANY MODIFICATIONS MUST KEEP THIS INVARIANT.
@@ -25,30 +29,47 @@ func CheckConsistency() error {
 	// TODO(mateuszc): check that all pkgs sharing same "repositoryRoot" have same "revision" & "revisionTime"
 
 	// Make sure we're in project's root dir (with .git/, vendor.json, and _vendor/)
-	exist := Exist{}.Dir(".git").File(JsonPath).Dir(VendorPath)
+	exist := Exist{}.GitDir(".git").File(JsonPath).Dir(VendorPath)
 	if exist.Err != nil {
-		return exist.Err
+		return nil, exist.Err
 	}
 
 	// Parse *vendor.json*, sort by pkg path.
 	// (use-cases.md 6.1.2.1.2)
 	pkgs, err := ReadStagedVendorFile(JsonPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if pkgs == nil {
-		return fmt.Errorf("file not found: %s", JsonPath)
+		return nil, fmt.Errorf("file not found: %s", JsonPath)
+	}
+
+	findings := Findings{}
+
+	// Git submodules ("gitlinks") would leave the vendored code out of the main repo; their contents must be added as plain
+	// files (see `vendo recreate --submodules`).
+	gitlinks, err := git{}.StagedGitlinks(".", VendorPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, gitlink := range gitlinks {
+		f := findings.Add(CheckIdConsistency, "unexpected git submodule (gitlink) in %s/, its contents should be added as plain files", VendorPath)
+		f.File = gitlink
+		f.Fix = fmt.Sprintf("run `git rm --cached %s`, then `vendo recreate --submodules`", gitlink)
 	}
 
 	// Build a tree from RepositoryRoots
 	repoRoots := Tree{}
 	unvisitedRoots := set{}
 	for _, p := range pkgs.Packages {
-		unvisitedRoots.Add(p.RepositoryRoot)
 		err := repoRoots.Put(p.RepositoryRoot)
 		if err != nil {
-			return err
+			f := findings.Add(CheckIdConsistency, "%s", err)
+			f.Package = p.Canonical
+			f.Fix = "run `vendo recreate`"
+			continue
 		}
+		unvisitedRoots.Add(p.RepositoryRoot)
 	}
 
 	// We want to check that all RepositoryRoots from vendor.json (from index) are in git (index), and that there are no files in _vendor/
@@ -65,14 +86,31 @@ func CheckConsistency() error {
 		}
 		expected := repoRoots.Get(path)
 		if expected == nil {
-			return fmt.Errorf("unexpected file/directory in git, but not in %s: %s", JsonPath, path)
+			// Some parent dirs of a repository root may be missing from git (e.g. in pruned repos), so we may get here a
+			// path which is inside a root.
+			if root := repoRoots.Root(path); root != "" {
+				delete(unvisitedRoots, root)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			f := findings.Add(CheckIdConsistency, "unexpected file/directory in git, but not in %s", JsonPath)
+			f.File = path
+			f.Fix = fmt.Sprintf("run `vendo recreate`, or `git rm --cached -r %s`", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		if !info.IsDir() {
-			return fmt.Errorf("unexpected file in git, not in any %s repositoryRoot: %s", JsonPath, path)
+			f := findings.Add(CheckIdConsistency, "unexpected file in git, not in any %s repositoryRoot", JsonPath)
+			f.File = path
+			f.Fix = fmt.Sprintf("run `vendo recreate`, or `git rm --cached %s`", path)
+			return nil
 		}
 		if expected.IsEmpty() { // repository root
-			// FIXME(mateuszc): if has *.git/.hg/.bzr* subdir, then verify revision-id match with *vendor.json*; if failed, report **error**
-			// (see *vendo-check-patched* for error message details); (use-cases.md 6.1.2.1.3.3)
+			// Note: *.git/.hg/.bzr* subdirs are verified below, in checkVcsDirs().
 			delete(unvisitedRoots, path)
 			return filepath.SkipDir
 		} else {
@@ -80,16 +118,132 @@ func CheckConsistency() error {
 		}
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// if any pkg in *vendor.json* is not visited, then report **error**;
 	// (use-cases.md 6.1.2.1.4)
-	if len(unvisitedRoots) > 0 {
-		return fmt.Errorf("following %s repositoryRoots not found in git: %s", JsonPath, strings.Join(unvisitedRoots.ToSlice(), " "))
+	unvisited := unvisitedRoots.ToSlice()
+	sort.Strings(unvisited)
+	for _, root := range unvisited {
+		f := findings.Add(CheckIdConsistency, "repositoryRoot listed in %s not found in git", JsonPath)
+		f.RepositoryRoot = root
+		f.Fix = fmt.Sprintf("run `vendo recreate`, or `git add %s`", root)
 	}
 
-	// TODO(mateuszc): check that any *.git/.hg/.bzr* subdirs, if present, are at locations noted in $PKG_REPO_ROOT fields;
-	return nil
+	// Check that any *.git/.hg/.bzr* subdirs, if present, are at locations noted in $PKG_REPO_ROOT fields, and have revisions
+	// matching *vendor.json*.
+	// (use-cases.md 6.1.2.1.3.3, 6.1.2.1.5)
+	vcsFindings, err := checkVcsDirs(pkgs)
+	if err != nil {
+		return nil, err
+	}
+	return append(findings, vcsFindings...), nil
+}
+
+// checkVcsDirs verifies that any *.git/.hg/.bzr* subdirs in and above the
+// repository roots listed in pkgs are only at the repository roots (or at
+// paths of vendored git submodules), and that the revisions checked out there
+// match the ones in vendor.json.
+//
+// NOTE: VCS metadata is never added to git, so this function must look at the
+// disk, not at git's "staging area". Directories in _vendor/ not related to any
+// repository root are not checked (they may be e.g. from user's GOPATH).
+func checkVcsDirs(pkgs *VendorFile) (Findings, error) {
+	vcsDirs := set{}
+	for _, vcs := range vcsList {
+		vcsDirs.Add(vcs.Dir())
+	}
+	byRoot := pkgs.ByRepositoryRoot()
+	roots := []string{}
+	submodules := set{}
+	for root, pkg := range byRoot {
+		roots = append(roots, root)
+		for _, submodule := range pkg.Submodules {
+			submodules.Add(path.Join(root, submodule.Path))
+		}
+	}
+	sort.Strings(roots)
+
+	findings := Findings{}
+	misplaced := set{}
+	for _, root := range roots {
+		// Check parent dirs of the root, up to _vendor/.
+		for dir := path.Dir(root); dir != VendorPath && dir != "." && dir != "/"; dir = path.Dir(dir) {
+			vcs, err := vcsList.IsRoot(dir)
+			if err != nil {
+				return nil, err
+			}
+			if vcs != nil && byRoot[dir] == nil {
+				misplaced.Add(path.Join(dir, vcs.Dir()))
+			}
+		}
+
+		// Check the root and its subdirs.
+		err := filepath.Walk(filepath.FromSlash(root), func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !info.IsDir() {
+				// A .git file of a git worktree or submodule checkout is metadata too (see git.IsRoot).
+				if info.Name() != (git{}).Dir() {
+					return nil
+				}
+				if _, _, err := (git{}).metadataDirs(filepath.Dir(p)); err != nil {
+					return nil
+				}
+			}
+			p = filepath.ToSlash(p)
+			if info.IsDir() && p != root && byRoot[p] != nil {
+				return filepath.SkipDir // nested repository root, will be checked separately
+			}
+			if _, isVcs := vcsDirs[info.Name()]; !isVcs {
+				return nil
+			}
+			parent := path.Dir(p)
+			if _, isSubmodule := submodules[parent]; parent != root && !isSubmodule {
+				misplaced.Add(p)
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		vcs, err := vcsList.IsRoot(root)
+		if err != nil {
+			return nil, err
+		}
+		if vcs == nil {
+			continue
+		}
+		pkg := byRoot[root]
+		diskRevision, err := vcs.Revision(root)
+		if err != nil {
+			return nil, err
+		}
+		if diskRevision != pkg.Revision {
+			f, err := revisionMismatchFinding(CheckIdConsistency, pkg, vcs, diskRevision)
+			if err != nil {
+				return nil, err
+			}
+			findings = append(findings, f)
+		}
+	}
+	dirs := misplaced.ToSlice()
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		f := findings.Add(CheckIdConsistency, "found version control metadata outside of %s repositoryRoots (nested or misplaced repository?)",
+			JsonPath)
+		f.File = dir
+		f.Fix = fmt.Sprintf("delete %s, or fix \"repositoryRoot\" in %s", dir, JsonPath)
+	}
+	return findings, nil
 }
 
 type Tree map[string]Tree
@@ -125,4 +279,21 @@ func (t Tree) Get(path string) Tree {
 	return subtree
 }
 
+// Root returns the longest prefix of path which is a leaf in t, or "" if not
+// found.
+func (t Tree) Root(path string) string {
+	segments := strings.Split(path, "/")
+	subtree := t
+	for i, seg := range segments {
+		subtree = subtree[seg]
+		switch {
+		case subtree == nil:
+			return ""
+		case subtree.IsEmpty():
+			return strings.Join(segments[:i+1], "/")
+		}
+	}
+	return ""
+}
+
 func (t Tree) IsEmpty() bool { return len(t) == 0 }
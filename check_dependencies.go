@@ -1,13 +1,14 @@
 package main
 
 import (
-	"fmt"
 	"reflect"
 	"sort"
-	"strings"
 )
 
-func CheckDependencies() error {
+// CheckDependencies verifies that packages listed in vendor.json match the
+// dependencies of the project. Problems are returned as findings; an error is
+// returned only if the check could not be completed.
+func CheckDependencies() (Findings, error) {
 	// (use-cases.md 6.1.2.2)
 
 	// NOTE: this function operates strictly on files in git's "staging area" (index).
@@ -16,89 +17,95 @@ func CheckDependencies() error {
 	// FIXME(mateuszc): write tests
 
 	// Make sure we're in project's root dir (with .git/, vendor.json, and _vendor/)
-	exist := Exist{}.Dir(".git").File(JsonPath).Dir(VendorPath)
+	exist := Exist{}.GitDir(".git").File(JsonPath).Dir(VendorPath)
 	if exist.Err != nil {
-		return exist.Err
+		return nil, exist.Err
 	}
 
 	// (use-cases.md 6.1.2.2.1)
 	stasher, err := GitStashUnstaged("vendo check-dependencies")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer stasher.Unstash()
 
 	// Check again after `git stash`
-	exist = Exist{}.Dir(".git").File(JsonPath).Dir(VendorPath)
+	exist = Exist{}.GitDir(".git").File(JsonPath).Dir(VendorPath)
 	if exist.Err != nil {
-		return exist.Err
+		return nil, exist.Err
 	}
 
 	// Transitively find package dependencies.
 	// (use-cases.md 6.1.2.2.2)
-	project, err := findProjectImportPath()
-	if err != nil {
-		return err
-	}
-	imports, err := findImportsGreedily(project)
-	if err != nil {
-		return err
-	}
 	vendorAbsPath, err := getVendorAbsPath()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Note: we don't need to merge with os.Getenv("GOPATH"). We still can find imports from outside _vendor/, only we won't get their
 	// dependencies, but that's not crucial.
 	gopath := vendorAbsPath
 	pkgs, err := ReadStagedVendorFile(JsonPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = imports.addTransitiveDependencies(gopath, pkgs.Platforms)
-	if err != nil {
-		return err
+	findings := Findings{}
+	if len(pkgs.Platforms) == 0 {
+		f := findings.Add(CheckIdDependencies, `empty or missing "platforms" in %s`, JsonPath)
+		f.Fix = "run `vendo recreate`"
+		return findings, nil
 	}
-	err = imports.removeStdlibs()
+	deps, err := crawlDependenciesCached(gopath, pkgs.Platforms)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// TODO(mateuszc): delete again subpackages of 'project' from the list (in case some thirdparty imported in-project pkg)
+	// If vendor.json was created with `recreate --no-test-deps`, dependencies of tests are expected to be missing.
+	// Note: subpackages of the project are never included in deps, even if some third-party package imports them.
 	// (use-cases.md 6.1.2.2.3)
+	imports, needed := deps.Imports(pkgs.NoTestDeps)
 	detectedImports := imports.ToSlice()
 	sort.Strings(detectedImports)
 
 	// Verify that list of depdendencies is equal to list of packages in vendor.json.
 	// (use-cases.md 6.1.2.2.4)
-	jsonImports := []string{}
-	for _, pkg := range pkgs.Packages {
-		jsonImports = append(jsonImports, pkg.Canonical)
+	// List the differences one by one, noting the platforms on which each new dependency is needed.
+	byCanonical := pkgs.ByCanonical()
+	for _, imp := range detectedImports {
+		if byCanonical[imp] == nil {
+			f := findings.Add(CheckIdDependencies, "package is a dependency, but is missing in %s", JsonPath)
+			if note := pkgs.PlatformsNote(&VendorPackage{Platforms: needed[imp].Platforms}); note != "" {
+				f.Message += " (" + note + ")"
+			}
+			f.Package = imp
+			f.Fix = "run `vendo recreate`"
+		}
 	}
-	sort.Strings(jsonImports)
-	if !reflect.DeepEqual(detectedImports, jsonImports) {
-		jsonList := strings.Join(jsonImports, " ")
-		detectedList := strings.Join(detectedImports, " ")
-		return fmt.Errorf(`the list of packages in %[1]s differs from the list of dependencies in crawled disk files:
-%[1]s:
-	%s
-crawled dependencies:
-	%s
-	%s
-	`,
-			JsonPath, jsonList, detectedList, mismatch(jsonList, detectedList))
+	for _, pkg := range pkgs.Packages {
+		if needed[pkg.Canonical] == nil {
+			f := findings.Add(CheckIdDependencies, "package listed in %s is not needed anymore", JsonPath)
+			f.Package, f.RepositoryRoot = pkg.Canonical, pkg.RepositoryRoot
+			f.Fix = "run `vendo recreate`"
+		}
 	}
 
-	return nil
-}
-
-func mismatch(a, b string) string {
-	if len(a) > len(b) {
-		a = a[:len(b)]
-	}
-	for i := range a {
-		if a[i] != b[i] {
-			return strings.Repeat(" ", i) + "↑"
+	// Verify that each package is classified the same as in vendor.json, and needed on the same platforms. Packages without "scope" or
+	// "platforms" (e.g. from vendor.json created by an older version of the tool) are not verified.
+	for _, pkg := range pkgs.Packages {
+		dep := needed[pkg.Canonical]
+		if dep == nil {
+			// already reported above
+			continue
+		}
+		if pkg.Scope != "" && pkg.Scope != dep.Scope {
+			f := findings.Add(CheckIdDependencies, "\"scope\" is %q in %s, but crawled as %q", pkg.Scope, JsonPath, dep.Scope)
+			f.Package = pkg.Canonical
+			f.Fix = "run `vendo recreate`"
+		}
+		if len(pkg.Platforms) > 0 && !reflect.DeepEqual(pkg.Platforms, dep.Platforms) {
+			f := findings.Add(CheckIdDependencies, "\"platforms\" are %s in %s, but crawled as needed on %s",
+				formatPlatforms(pkg.Platforms), JsonPath, formatPlatforms(dep.Platforms))
+			f.Package = pkg.Canonical
+			f.Fix = "run `vendo recreate`"
 		}
 	}
-	return strings.Repeat(" ", len(a)) + "↑"
+	return findings, nil
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// StagedChanges describes which of the changes staged in git's index
+// ("staging area") may affect results of the checks.
+type StagedChanges struct {
+	// Vendor is true if vendor.json or any file in _vendor/ was changed.
+	Vendor bool
+	// Imports lists project's *.go files with imports changed vs. HEAD.
+	Imports []string
+}
+
+// findStagedChanges analyzes only the *.go files changed in git's "staging
+// area", comparing their imports with the versions in HEAD. This is much
+// faster than crawling all the dependencies, and allows 'vendo check --fast'
+// to skip the checks for commits which don't touch imports nor vendored code.
+// (use-cases.md 6.1.1.1)
+func findStagedChanges() (*StagedChanges, error) {
+	defer Phase("staged changes")()
+	dirtyFiles, err := findDirtyStagedFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	changes := &StagedChanges{}
+	fset := token.NewFileSet()
+	for _, file := range dirtyFiles {
+		if file == JsonPath || isSubdir(file, VendorPath) {
+			changes.Vendor = true
+			continue
+		}
+		if path.Ext(file) != ".go" || ignoredByGo(file) {
+			continue
+		}
+		oldImports, err := readImports(fset, file, git{}.ReadHead)
+		if err != nil {
+			return nil, err
+		}
+		newImports, err := readImports(fset, file, git{}.ReadStaged)
+		if err != nil {
+			// Broken file in index; let the full check report it.
+			changes.Imports = append(changes.Imports, file)
+			continue
+		}
+		if !reflect.DeepEqual(oldImports, newImports) {
+			changes.Imports = append(changes.Imports, file)
+		}
+	}
+	return changes, nil
+}
+
+// ignoredByGo returns true if any element of slash-separated file path is
+// ignored by 'go build': "testdata", "_*", or ".*".
+func ignoredByGo(file string) bool {
+	for _, name := range strings.Split(file, "/") {
+		if name == "testdata" || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// readImports returns sorted list of imports in *.go file, read using
+// readFunc (e.g. git{}.ReadStaged). Missing file has no imports.
+func readImports(fset *token.FileSet, file string, readFunc func(root, subpath string) (io.ReadCloser, error)) ([]string, error) {
+	r, err := readFunc(".", file)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parser.ParseFile(fset, file, data, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	imports := []string{}
+	for _, imp := range parsed.Imports {
+		if imp == nil || imp.Path == nil {
+			continue
+		}
+		imports = append(imports, strings.Trim(imp.Path.Value, "`\""))
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
-	"errors"
 	"fmt"
-	"reflect"
+	"sort"
 	"strings"
 )
 
 // CheckPatched checks that all packages imported by project are listed in the
-// *vendor.json* file, and no others.
+// *vendor.json* file, and no others. Problems are returned as findings; an
+// error is returned only if the check could not be completed.
 // (use-cases.md 7.1.1)
-func CheckPatched() error {
+func CheckPatched() (Findings, error) {
 
 	// NOTE: this function operates strictly on files in git's "staging area" (index).
 	// ANY MODIFICATIONS MUST KEEP THIS INVARIANT.
@@ -20,9 +20,9 @@ func CheckPatched() error {
 	// FIXME(mateuszc): write tests
 
 	// Make sure we're in project's root dir (with .git/, vendor.json, and _vendor/)
-	exist := Exist{}.Dir(".git").File(JsonPath).Dir(VendorPath)
+	exist := Exist{}.GitDir(".git").File(JsonPath).Dir(VendorPath)
 	if exist.Err != nil {
-		return exist.Err
+		return nil, exist.Err
 	}
 
 	// We want the "current on-disk" state of files to reflect the content of git's "staging area" ("index").  Because
@@ -30,61 +30,59 @@ func CheckPatched() error {
 	// content.
 	stasher, err := GitStashUnstaged("vendo check-patched")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer stasher.Unstash()
 
 	// Check again after `git stash`
-	exist = Exist{}.Dir(".git").File(JsonPath).Dir(VendorPath)
+	exist = Exist{}.GitDir(".git").File(JsonPath).Dir(VendorPath)
 	if exist.Err != nil {
-		return exist.Err
+		return nil, exist.Err
 	}
 
-	dirtyFiles, err := findDirtyStagedFiles()
+	dirtyFiles, err := findDirtyStagedFiles(VendorPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(dirtyFiles) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Find repos, based on ReadStagedVendorFile() & Tree{}
 	// (use-cases.md 7.1.1.2)
 	pkgs, err := ReadStagedVendorFile(JsonPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	findings := Findings{}
 	dirtyRoots, unmatchedFiles := pkgs.findReposOfFiles(dirtyFiles)
-	if len(unmatchedFiles) > 0 && !reflect.DeepEqual(unmatchedFiles, []string{GitignorePath}) {
-		// Show error message to user, listing all unmatched files except _vendor/.gitignore
-		filtered := []string{}
-		for _, file := range unmatchedFiles {
-			if file != GitignorePath {
-				filtered = append(filtered, file)
-			}
+	// Report all unmatched files except _vendor/.gitignore
+	for _, file := range unmatchedFiles {
+		if file == GitignorePath {
+			continue
 		}
-		return fmt.Errorf(`cannot find matching "repositoryRoot" in %s for following files: %s`,
-			JsonPath, strings.Join(filtered, " "))
+		f := findings.Add(CheckIdPatched, `cannot find matching "repositoryRoot" in %s`, JsonPath)
+		f.File = file
 	}
 
 	oldPkgs, err := ReadHeadVendorFile(JsonPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// (use-cases.md 7.1.1.3); more info in function's comment
-	err = verifyCommentsForPatchedRepos(dirtyRoots, oldPkgs.ByRepositoryRoot(), pkgs.ByRepositoryRoot())
+	patchedFindings, err := verifyCommentsForPatchedRepos(dirtyRoots, oldPkgs.ByRepositoryRoot(), pkgs)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return append(findings, patchedFindings...), nil
 }
 
-// findDirtyStagedFiles returns paths of all modified files added to git index
-// ("staging area"). In other words, all files shown by `git status` as "Changes
-// to be committed".
+// findDirtyStagedFiles returns paths of all modified files under path, added to
+// git index ("staging area"). In other words, all files shown by `git status`
+// as "Changes to be committed".
 // (use-cases.md 7.1.1.1)
-func findDirtyStagedFiles() ([]string, error) {
+func findDirtyStagedFiles(path string) ([]string, error) {
 	// `git status --porcelain` => find staged files (line[0] not in " !?"); for rename, collect both file names
 	// FIXME(mateuszc): test parsing of `git status --porcelain` for files with unicode chars & renaming a file named '->'
 	// TODO(mateuszc): consider using a third-party git library. Known packages
@@ -95,7 +93,7 @@ func findDirtyStagedFiles() ([]string, error) {
 	//    (-) doesn't support index (staging area)
 	// - http://godoc.org/github.com/gogits/git
 	//    (-) doesn't support index (staging area)
-	lines, err := Command("git", "status", "--porcelain", VendorPath).
+	lines, err := Command("git", "status", "--porcelain", path).
 		OutputLines()
 	if err != nil {
 		return nil, err
@@ -113,7 +111,13 @@ func findDirtyStagedFiles() ([]string, error) {
 	//	A  "with space"
 	//	?? notrak
 	dirtyFiles := []string{}
-	for _, line := range lines {
+	for i, line := range lines {
+		// NOTE: leading space of first line is trimmed by
+		// OutputLines, so " M bingo" becomes "M bingo". Status is always
+		// followed by a space, which lets us restore it.
+		if i == 0 && len(line) > 2 && line[1] == ' ' && line[2] != ' ' {
+			line = " " + line
+		}
 		// Skip files not changed in index (staging area)
 		status := line[0]
 		if strings.IndexByte(" !?", status) != -1 {
@@ -155,63 +159,57 @@ func findDirtyStagedFiles() ([]string, error) {
 	return dirtyFiles, nil
 }
 
+// vcsMetadataUnavailable is set when checking committed trees (see
+// CheckRange), where vendored repositories never have .git/.hg/.bzr subdirs.
+// Changes which cannot be verified without them are then reported as
+// warnings.
+var vcsMetadataUnavailable = false
+
 // verifyCommentsForPatchedRepos checks all the repositories specified as
 // repoRoots.  If any of them are detected as patched locally (vs. upstream,
 // i.e. origin), the function verifies that the "comment" field was edited in
 // vendor.json for corresponding packages (it should mention the patch).
 // (use-cases.md 7.1.1.3)
-func verifyCommentsForPatchedRepos(repoRoots set, oldByRepoRoot, newByRepoRoot map[string]*VendorPackage) error {
+func verifyCommentsForPatchedRepos(repoRoots set, oldByRepoRoot map[string]*VendorPackage, pkgs *VendorFile) (Findings, error) {
+	newByRepoRoot := pkgs.ByRepositoryRoot()
+	findings := Findings{}
+	roots := repoRoots.ToSlice()
+	sort.Strings(roots)
 	// Iterate all repository roots with changes, and make sure that those changes are reflected in changed Comment.
-	for root := range repoRoots {
+	for _, root := range roots {
 		pkg := newByRepoRoot[root]
 		if pkg == nil {
-			return fmt.Errorf(`directory %s has a modified file, but does not match any "repositoryRoot" in %s`,
-				root, JsonPath)
+			f := findings.Add(CheckIdPatched, `directory has a modified file, but does not match any "repositoryRoot" in %s`,
+				JsonPath)
+			f.File = root
+			continue
 		}
 		vcs, err := vcsList.IsRoot(root)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if vcs != nil {
 			// If current Revision in subrepo (via git/hg/bzr) differs from Revision from *vendor.json*, report **error**.
 			// (use-cases.md 7.1.1.3.1.1)
 			diskRevision, err := vcs.Revision(root)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			jsonRevision := pkg.Revision
-			if diskRevision != jsonRevision {
-				// TODO(mateuszc): improve the message to full format as in use-cases.md 7.1.1.3.1.1
-				msg := `The revision in local repository at $PKG_REPO_ROOT:
-  $PKG_LOCAL_REVISION $PKG_LOCAL_REV_DATE $PKG_LOCAL_REV_COMMENT
-is inconsistent with information stored in 'vendor.json' for package $PKG:
-  $PKG_REPO_REVISION $PKG_REPO_REV_DATE
-  comment: $PKG_JSON_COMMENT
-To fix the inconsistency, you are advised do one of the following actions,
-depending on which is most appropriate in your case:
-  a) revert $PKG_REPO_ROOT to $PKG_REPO_REVISION;
-  b) update "revision" in 'vendor.json' to $PKG_LOCAL_REVISION;
-  c) delete $PKG_REPO_ROOT/$VCS_DIR`
-				msg = strings.NewReplacer(
-					"$PKG_REPO_ROOT", pkg.RepositoryRoot,
-					"$PKG_LOCAL_REVISION", diskRevision,
-					"$PKG_LOCAL_REV_DATE", "", // TODO(mateuszc)
-					"$PKG_LOCAL_REV_COMMENT", "", // TODO(mateuszc)
-					"$PKG_REPO_REVISION", jsonRevision,
-					"$PKG_REPO_REV_DATE", pkg.RevisionTime,
-					"$PKG_JSON_COMMENT", pkg.Comment,
-					"$PKG", pkg.Canonical,
-					"$VCS_DIR", vcs.Dir(),
-					"vendor.json", JsonPath,
-				).Replace(msg)
-				return errors.New(msg)
+			if diskRevision != pkg.Revision {
+				f, err := revisionMismatchFinding(CheckIdPatched, pkg, vcs, diskRevision)
+				if err != nil {
+					return nil, err
+				}
+				findings = append(findings, f)
+				continue
 			}
 			// Check if the subrepo is clean for the tested Revision.
 			// (use-cases.md 7.1.1.3.1.2)
 			// TODO(mateuszc): check files untracked in subrepo (but tracked in main repo) too?
-			clean, err := vcs.IsClean(root, ".")
+			// NOTE: files missing in pruned repos are expected, see isCleanInRepo.
+			clean, err := isCleanInRepo(vcs, pkgs, pkg)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if clean {
 				continue
@@ -223,16 +221,76 @@ depending on which is most appropriate in your case:
 		// FIXME(mateuszc): do this for all pkgs with the same RepositoryRoot
 		oldPkg := oldByRepoRoot[root]
 		switch {
+		case oldPkg == nil && vcs == nil && vcsMetadataUnavailable:
+			f := findings.Add(CheckIdPatched, "cannot verify if new repository is pristine, no Version Control System metadata")
+			f.Severity = SeverityWarning
+			f.Package, f.RepositoryRoot = pkg.Canonical, pkg.RepositoryRoot
 		case oldPkg == nil:
 			// New pkg added, apparently.
 			if vcs != nil {
-				return fmt.Errorf("sub-repository in: %s not clean in git index; please add pristine repository first, then add any local patches in separate commit later", pkg.RepositoryRoot)
+				f := findings.Add(CheckIdPatched, "sub-repository not clean in git index")
+				f.Package, f.RepositoryRoot = pkg.Canonical, pkg.RepositoryRoot
+				f.Fix = "add pristine repository first, then add any local patches in separate commit later"
 			} else {
-				return fmt.Errorf("cannot detect Version Control System in: %s", pkg.RepositoryRoot)
+				f := findings.Add(CheckIdPatched, "cannot detect Version Control System")
+				f.Package, f.RepositoryRoot = pkg.Canonical, pkg.RepositoryRoot
 			}
+		case vcs == nil && vcsMetadataUnavailable && oldPkg.Revision != pkg.Revision:
+			// We cannot tell an update of the repository from a local patch.
+			f := findings.Add(CheckIdPatched, "cannot verify if repository is patched, no Version Control System metadata; "+
+				"revision changed from %s to %s", oldPkg.Revision, pkg.Revision)
+			f.Severity = SeverityWarning
+			f.Package, f.RepositoryRoot = pkg.Canonical, pkg.RepositoryRoot
 		case oldPkg.Comment == pkg.Comment:
-			return fmt.Errorf("local patch detected in: %s; please edit \"comment\" in %s to add note describing the patch", pkg.RepositoryRoot, JsonPath)
+			f := findings.Add(CheckIdPatched, "local patch detected")
+			f.Package, f.RepositoryRoot = pkg.Canonical, pkg.RepositoryRoot
+			f.Fix = fmt.Sprintf("edit \"comment\" in %s to add note describing the patch", JsonPath)
 		}
 	}
-	return nil
+	return findings, nil
+}
+
+// revisionMismatchFinding builds a finding describing that revision checked
+// out in repository root of pkg (diskRevision) differs from the one recorded
+// in vendor.json, with suggestions how to fix it.
+// (use-cases.md 7.1.1.3.1.1)
+func revisionMismatchFinding(check string, pkg *VendorPackage, vcs Vcs, diskRevision string) (*Finding, error) {
+	diskRevisionTime, err := vcs.RevisionTime(pkg.RepositoryRoot)
+	if err != nil {
+		return nil, err
+	}
+	diskRevisionSubject, err := vcs.RevisionSubject(pkg.RepositoryRoot)
+	if err != nil {
+		return nil, err
+	}
+	msg := `The revision in local repository at $PKG_REPO_ROOT:
+  $PKG_LOCAL_REVISION $PKG_LOCAL_REV_DATE $PKG_LOCAL_REV_COMMENT
+is inconsistent with information stored in 'vendor.json' for package $PKG:
+  $PKG_REPO_REVISION $PKG_REPO_REV_DATE
+  comment: $PKG_JSON_COMMENT`
+	fix := `To fix the inconsistency, you are advised do one of the following actions,
+depending on which is most appropriate in your case:
+  a) revert $PKG_REPO_ROOT to $PKG_REPO_REVISION;
+  b) update "revision" in 'vendor.json' to $PKG_LOCAL_REVISION;
+  c) delete $PKG_REPO_ROOT/$VCS_DIR`
+	replacer := strings.NewReplacer(
+		"$PKG_REPO_ROOT", pkg.RepositoryRoot,
+		"$PKG_LOCAL_REVISION", diskRevision,
+		"$PKG_LOCAL_REV_DATE", diskRevisionTime,
+		"$PKG_LOCAL_REV_COMMENT", diskRevisionSubject,
+		"$PKG_REPO_REVISION", pkg.Revision,
+		"$PKG_REPO_REV_DATE", pkg.RevisionTime,
+		"$PKG_JSON_COMMENT", pkg.Comment,
+		"$PKG", pkg.Canonical,
+		"$VCS_DIR", vcs.Dir(),
+		"vendor.json", JsonPath,
+	)
+	return &Finding{
+		Check:          check,
+		Severity:       SeverityError,
+		Package:        pkg.Canonical,
+		RepositoryRoot: pkg.RepositoryRoot,
+		Message:        replacer.Replace(msg),
+		Fix:            replacer.Replace(fix),
+	}, nil
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CommitFindings are results of checks run for a single commit.
+type CommitFindings struct {
+	Commit   string   `json:"commit"`
+	Subject  string   `json:"subject"`
+	Findings Findings `json:"findings"`
+	// NotVendored is set if the commit has no vendor.json (e.g. it's from
+	// before vendo was adopted, or on an unrelated branch), so no checks were
+	// run.
+	NotVendored bool `json:"notVendored,omitempty"`
+	// Checks are IDs of the checks which were run.
+	Checks []string `json:"-"`
+}
+
+type RangeFindings []*CommitFindings
+
+// CheckRange runs all the checks for each commit in revRange (e.g. "A..B",
+// see `git help rev-list`), in the same way as they would be run in a
+// pre-commit hook when creating the commit on top of its (first) parent. This
+// allows to catch bad vendoring committed with `git commit --no-verify`.
+//
+// The commits are checked out in a temporary, shared clone of the repository
+// at repoDir, placed in a temporary GOPATH at project's importPath. In the
+// clone, HEAD is set to the parent commit, while git's "staging area" and
+// disk contain the checked commit - so vendor.json and _vendor/ are read from
+// the commit, and compared with its parent.
+//
+// The commits are selected by revs, which are arguments to `git log` (e.g.
+// "A..B", or "B --not --all"). CheckRange can be run from git hooks, also in a
+// bare repository (see PreReceive). Commits without vendor.json are skipped.
+func CheckRange(repoDir, importPath string, revs ...string) (RangeFindings, error) {
+	commits, err := listCommits(repoDir, revs)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return RangeFindings{}, nil
+	}
+
+	// Variables like GIT_DIR, set by git when running hooks, would make the
+	// git commands below operate on the original repository instead of the
+	// clone.
+	objectDirs, restoreEnv, err := unsetGitEnv()
+	if err != nil {
+		return nil, err
+	}
+	defer restoreEnv()
+
+	tmp, err := ioutil.TempDir("", "vendo-range")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	defer AddCleanup(func() { os.RemoveAll(tmp) })()
+	cloneDir := filepath.Join(tmp, "src", filepath.FromSlash(importPath))
+	err = os.MkdirAll(filepath.Dir(cloneDir), 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = Command("git", "clone", "--quiet", "--shared", "--no-checkout", repoDir, cloneDir).
+		DiscardOutput()
+	if err != nil {
+		return nil, err
+	}
+	// Objects received by git in a push are stored in a "quarantine"
+	// directory until the pre-receive hook succeeds.
+	err = addAlternates(cloneDir, objectDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The checks assume they're run in project's root dir, in a GOPATH.
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chdir(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Chdir(cwd)
+	gopath := os.Getenv("GOPATH")
+	os.Setenv("GOPATH", tmp)
+	defer os.Setenv("GOPATH", gopath)
+
+	vcsMetadataUnavailable = true
+	defer func() { vcsMetadataUnavailable = false }()
+	// See checkoutForCheck.
+	noUnstagedChanges = true
+	defer func() { noUnstagedChanges = false }()
+
+	results := RangeFindings{}
+	for _, commit := range commits {
+		files, err := Command("git", "ls-tree", "--name-only", commit.Commit, "--", JsonPath).
+			OutputLines()
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			commit.NotVendored = true
+			results = append(results, commit.CommitFindings)
+			continue
+		}
+		err = checkoutForCheck(commit.Commit, commit.parent)
+		if err != nil {
+			return nil, err
+		}
+		commit.Findings, commit.Checks = Check(false)
+		results = append(results, commit.CommitFindings)
+	}
+	return results, nil
+}
+
+type rangeCommit struct {
+	*CommitFindings
+	parent string
+}
+
+// listCommits returns commits selected by revs, oldest first, with their
+// first parents.
+func listCommits(repoDir string, revs []string) ([]rangeCommit, error) {
+	args := []string{"log", "--reverse", "--topo-order", "--format=%H%x00%P%x00%s"}
+	args = append(args, revs...)
+	cmd := Command("git", append(args, "--")...)
+	cmd.Cmd.Dir = repoDir
+	lines, err := cmd.OutputLines()
+	if err != nil {
+		return nil, err
+	}
+	commits := []rangeCommit{}
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\x00", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected format of git output: %q", line)
+		}
+		parent := ""
+		if parents := strings.Fields(fields[1]); len(parents) > 0 {
+			parent = parents[0]
+		}
+		commits = append(commits, rangeCommit{
+			CommitFindings: &CommitFindings{Commit: fields[0], Subject: fields[2]},
+			parent:         parent,
+		})
+	}
+	return commits, nil
+}
+
+// gitEnvVars are environment variables set by git when running hooks, which
+// change the repository that git commands operate on.
+var gitEnvVars = []string{"GIT_DIR", "GIT_WORK_TREE", "GIT_INDEX_FILE", "GIT_OBJECT_DIRECTORY",
+	"GIT_ALTERNATE_OBJECT_DIRECTORIES", "GIT_QUARANTINE_PATH", "GIT_PREFIX"}
+
+// unsetGitEnv removes gitEnvVars from environment, and returns absolute paths
+// of object directories that were pointed to by them, and a function to
+// restore the environment.
+func unsetGitEnv() (objectDirs []string, restore func(), err error) {
+	if dir := os.Getenv("GIT_OBJECT_DIRECTORY"); dir != "" {
+		objectDirs = append(objectDirs, dir)
+	}
+	if dirs := os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES"); dirs != "" {
+		objectDirs = append(objectDirs, filepath.SplitList(dirs)...)
+	}
+	for i, dir := range objectDirs {
+		objectDirs[i], err = filepath.Abs(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	saved := map[string]string{}
+	for _, name := range gitEnvVars {
+		if value, found := os.LookupEnv(name); found {
+			saved[name] = value
+			os.Unsetenv(name)
+		}
+	}
+	restore = func() {
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}
+	return objectDirs, restore, nil
+}
+
+// addAlternates makes objects from objectDirs available in git repository at
+// dir (see `git help gitrepository-layout` -> "objects/info/alternates").
+func addAlternates(dir string, objectDirs []string) error {
+	if len(objectDirs) == 0 {
+		return nil
+	}
+	path := filepath.Join(dir, ".git", "objects", "info", "alternates")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	for _, objectDir := range objectDirs {
+		_, err = fmt.Fprintln(f, objectDir)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// checkoutForCheck sets the repository in current directory to a state as if
+// commit was about to be committed on top of parent: files on disk and in
+// git's "staging area" are from commit, and HEAD points to parent. If parent
+// is empty, HEAD is set to an unborn branch. There are no unstaged changes
+// nor untracked files afterwards.
+func checkoutForCheck(commit, parent string) error {
+	defer Phase("checkout")()
+	err := Command("git", "checkout", "--quiet", "--force", "--detach", commit).
+		DiscardOutput()
+	if err != nil {
+		return err
+	}
+	err = Command("git", "clean", "--quiet", "-ffdx").
+		DiscardOutput()
+	if err != nil {
+		return err
+	}
+	if parent == "" {
+		return Command("git", "symbolic-ref", "HEAD", "refs/heads/vendo-unborn").
+			DiscardOutput()
+	}
+	return Command("git", "reset", "--quiet", "--soft", parent).
+		DiscardOutput()
+}
+
+// ExitCode returns bitwise OR of exit codes for all commits in r (see
+// Findings.ExitCode).
+func (r RangeFindings) ExitCode() int {
+	code := 0
+	for _, commit := range r {
+		code |= commit.Findings.ExitCode()
+	}
+	return code
+}
+
+func (r RangeFindings) WriteText(w io.Writer) error {
+	for _, commit := range r {
+		status := "ok"
+		switch {
+		case commit.NotVendored:
+			status = "not vendored (no " + JsonPath + "), skipped"
+		case commit.Findings.ExitCode() != 0:
+			status = "FAILED"
+		}
+		_, err := fmt.Fprintf(w, "%s %s: %s\n", commit.Commit[:12], commit.Subject, status)
+		if err != nil {
+			return err
+		}
+		err = commit.Findings.WriteText(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r RangeFindings) WriteJson(w io.Writer) error {
+	for _, commit := range r {
+		if commit.Findings == nil {
+			commit.Findings = Findings{}
+		}
+	}
+	buf, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", buf)
+	return err
+}
+
+// WriteJunit writes r as JUnit XML report, with a test suite for each check of
+// each commit, named "<commit>/<check>".
+func (r RangeFindings) WriteJunit(w io.Writer) error {
+	report := junitTestSuites{Name: "vendo check"}
+	for _, commit := range r {
+		report.add(commit.Findings.junitSuites(commit.Commit[:12]+"/", commit.Checks))
+	}
+	return report.write(w)
+}
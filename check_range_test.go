@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testCheckRange runs CheckRange for revs in the project in current directory
+// (see testVendoredProject), and returns results keyed by commit subject.
+func testCheckRange(test *testing.T, revs ...string) map[string]*CommitFindings {
+	dir, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	results, err := CheckRange(dir, "example.com/proj", revs...)
+	if err != nil {
+		test.Fatal(err)
+	}
+	bySubject := map[string]*CommitFindings{}
+	for _, commit := range results {
+		bySubject[commit.Subject] = commit
+	}
+	return bySubject
+}
+
+func Test_CheckRange_NotVendored(test *testing.T) {
+	_, cleanup := testVendoredProject(test, func(upstream string) string { return upstream })
+	defer cleanup()
+	testRun(test, ".", "git", "checkout", "-q", "--orphan", "docs")
+	testRun(test, ".", "git", "rm", "-q", "-r", "--cached", ".")
+	testRun(test, ".", "git", "clean", "-q", "-ffdx")
+	writeTree(test, ".", map[string]string{"README": "docs\n"})
+	testRun(test, ".", "git", "add", "README")
+	testRun(test, ".", "git", "commit", "-q", "-m", "docs")
+
+	results := testCheckRange(test, "docs")
+	docs := results["docs"]
+	switch {
+	case len(results) != 1 || docs == nil:
+		test.Fatalf("expected results for commit \"docs\", got %v", results)
+	case !docs.NotVendored || len(docs.Findings) != 0 || len(docs.Checks) != 0:
+		test.Errorf("expected commit without %s to be skipped, got %+v", JsonPath, docs)
+	}
+	if code := (RangeFindings{docs}).ExitCode(); code != 0 {
+		test.Errorf("expected exit code 0, got %d", code)
+	}
+}
+
+// Test_CheckRange checks a history with a root commit, a good one, a bad one,
+// and a merge of a bad branch.
+func Test_CheckRange(test *testing.T) {
+	_, cleanup := testVendoredProject(test, func(upstream string) string { return upstream })
+	defer cleanup()
+	commit := func(message string, files map[string]string) {
+		writeTree(test, ".", files)
+		testRun(test, ".", "git", "add", "-A")
+		testRun(test, ".", "git", "commit", "-q", "-m", message)
+	}
+	commit("good", map[string]string{"main.go": "package main\n\nimport \"example.com/dep\"\n\n// main says hello.\nfunc main() { dep.Hello() }\n"})
+	testRun(test, ".", "git", "branch", "side")
+	commit("bad", map[string]string{"_vendor/src/example.com/dep/dep.go": "package dep\n\nfunc Hello() { println() }\n"})
+	testRun(test, ".", "git", "checkout", "-q", "side")
+	commit("side", map[string]string{"util.go": "package main\n\nimport _ \"example.com/missing\"\n"})
+	testRun(test, ".", "git", "checkout", "-q", "master")
+	testRun(test, ".", "git", "merge", "-q", "--no-ff", "-m", "merge", "side")
+	head := testRun(test, ".", "git", "rev-parse", "HEAD")
+
+	dir, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	results, err := CheckRange(dir, "example.com/proj", "HEAD")
+	if err != nil {
+		test.Fatal(err)
+	}
+	// NOTE: the merge is checked against its first parent, so the patch from "bad" is not reported again.
+	expected := map[string]int{
+		"vendor": 0, // root commit
+		"good":   0,
+		"bad":    ExitPatched,
+		"side":   ExitDependencies,
+		"merge":  ExitDependencies,
+	}
+	if len(results) != len(expected) || results[0].Subject != "vendor" || results[len(results)-1].Subject != "merge" {
+		test.Errorf("expected %d commits, oldest first, got %d: %v", len(expected), len(results), results)
+	}
+	for _, commit := range results {
+		if code := commit.Findings.ExitCode(); code != expected[commit.Subject] || commit.NotVendored {
+			test.Errorf("%s: expected exit code %d, got %d:\n%v", commit.Subject, expected[commit.Subject], code, commit.Findings)
+		}
+	}
+	if code := results.ExitCode(); code != ExitPatched|ExitDependencies {
+		test.Errorf("expected exit code %d, got %d", ExitPatched|ExitDependencies, code)
+	}
+
+	// Only commits in the range are checked, and the repository is not modified.
+	results, err = CheckRange(dir, "example.com/proj", "HEAD~1..side")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Subject != "side" {
+		test.Errorf("expected only commit \"side\" in range HEAD~1..side, got %v", results)
+	}
+	if now := testRun(test, ".", "git", "rev-parse", "HEAD"); now != head {
+		test.Errorf("expected HEAD %s, got %s", head, now)
+	}
+	if status := testRun(test, ".", "git", "status", "--porcelain"); status != "" {
+		test.Errorf("expected clean repository, got:\n%s", status)
+	}
+}
+
+func Test_checkoutForCheck(test *testing.T) {
+	dir, cleanup := testProject(test)
+	defer cleanup()
+	writeTree(test, dir, map[string]string{"a.go": "package a\n"})
+	testRun(test, dir, "git", "add", "-A")
+	testRun(test, dir, "git", "commit", "-q", "-m", "first")
+	first := testRun(test, dir, "git", "rev-parse", "HEAD")
+	writeTree(test, dir, map[string]string{"a.go": "package a\n\nvar A int\n", "b.go": "package a\n"})
+	testRun(test, dir, "git", "add", "-A")
+	testRun(test, dir, "git", "commit", "-q", "-m", "second")
+	second := testRun(test, dir, "git", "rev-parse", "HEAD")
+	writeTree(test, dir, map[string]string{"untracked.go": "package a\n", "a.go": "modified\n"})
+
+	cases := []struct {
+		note, commit, parent string
+		head, staged         string
+	}{
+		{"second", second, first, first, "M\ta.go\nA\tb.go"},
+		{"root", first, "", "", "A\ta.go"},
+	}
+	for _, c := range cases {
+		err := checkoutForCheck(c.commit, c.parent)
+		if err != nil {
+			test.Fatal(err)
+		}
+		head, _ := Command("git", "rev-parse", "--verify", "-q", "HEAD").LogNever().OutputOneLine()
+		if head != c.head {
+			test.Errorf("%s: expected HEAD %q, got %q", c.note, c.head, head)
+		}
+		// On an unborn branch, the staging area is compared with an empty tree.
+		base := c.parent
+		if base == "" {
+			base = testRun(test, dir, "git", "hash-object", "-t", "tree", os.DevNull)
+		}
+		if staged := testRun(test, dir, "git", "diff", "--cached", "--name-status", base); staged != c.staged {
+			test.Errorf("%s: expected staged changes:\n%s\ngot:\n%s", c.note, c.staged, staged)
+		}
+		if status := testRun(test, dir, "git", "status", "--porcelain", "--untracked-files=all"); strings.Contains(status, " M ") || strings.Contains(status, "??") {
+			test.Errorf("%s: expected no unstaged changes nor untracked files, got:\n%s", c.note, status)
+		}
+	}
+}
+
+func Test_unsetGitEnv(test *testing.T) {
+	saved := map[string]string{}
+	for _, name := range gitEnvVars {
+		if value, found := os.LookupEnv(name); found {
+			saved[name] = value
+		}
+		os.Unsetenv(name)
+	}
+	defer func() {
+		for _, name := range gitEnvVars {
+			os.Unsetenv(name)
+		}
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}()
+	os.Setenv("GIT_DIR", ".")
+	os.Setenv("GIT_OBJECT_DIRECTORY", "quarantine")
+	os.Setenv("GIT_ALTERNATE_OBJECT_DIRECTORIES", strings.Join([]string{"objects", "/other"}, string(filepath.ListSeparator)))
+
+	objectDirs, restore, err := unsetGitEnv()
+	if err != nil {
+		test.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected := []string{filepath.Join(cwd, "quarantine"), filepath.Join(cwd, "objects"), filepath.FromSlash("/other")}
+	if runtime.GOOS == "windows" {
+		expected[2], _ = filepath.Abs(expected[2])
+	}
+	if !reflect.DeepEqual(objectDirs, expected) {
+		test.Errorf("expected object dirs %q, got %q", expected, objectDirs)
+	}
+	for _, name := range gitEnvVars {
+		if value, found := os.LookupEnv(name); found {
+			test.Errorf("expected %s unset, got %q", name, value)
+		}
+	}
+	restore()
+	if dir := os.Getenv("GIT_OBJECT_DIRECTORY"); dir != "quarantine" {
+		test.Errorf("expected GIT_OBJECT_DIRECTORY restored, got %q", dir)
+	}
+}
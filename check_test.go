@@ -1,7 +1,14 @@
 package main
 
 import (
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -27,3 +34,193 @@ func Test_Vendo_Tree_Insert(test *testing.T) {
 		test.Errorf("expected:\n%v\ngot:\n%v", expected, t)
 	}
 }
+
+func Test_Vendo_Tree_Root(test *testing.T) {
+	t := Tree{}
+	t.Put("_vendor/src/github.com/foo/bar")
+	t.Put("_vendor/src/golang.org/x/net")
+	cases := map[string]string{
+		"_vendor/src/github.com/foo/bar/baz":       "_vendor/src/github.com/foo/bar",
+		"_vendor/src/github.com/foo/bar/baz/a.go":  "_vendor/src/github.com/foo/bar",
+		"_vendor/src/golang.org/x/net/http2/h2.go": "_vendor/src/golang.org/x/net",
+		"_vendor/src/github.com/foo":               "",
+		"_vendor/src/github.com/foo/bang":          "",
+	}
+	for path, expected := range cases {
+		if root := t.Root(path); root != expected {
+			test.Errorf("Root(%q): expected %q, got %q", path, expected, root)
+		}
+	}
+}
+
+func Test_checkVcsDirs(test *testing.T) {
+	dir, err := ioutil.TempDir("", "vendo-check")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	// NOTE: .git files of submodule checkouts must point to existing git dirs.
+	gitdir := filepath.Join(dir, "modules", "s")
+	err = os.MkdirAll(gitdir, 0755)
+	if err != nil {
+		test.Fatal(err)
+	}
+	writeTree(test, ".", map[string]string{
+		"_vendor/src/example.com/a/a.go":               "package a\n",
+		"_vendor/src/example.com/a/sub/.hg/store":      "",
+		"_vendor/src/example.com/a/third_party/s/.git": "gitdir: " + gitdir + "\n",
+		"_vendor/src/example.com/b/b.go":               "package b\n",
+		"_vendor/src/other.com/c/.git/HEAD":            "", // not vendored, ignored
+	})
+	pkgs := &VendorFile{Packages: []*VendorPackage{
+		{Canonical: "example.com/a", RepositoryRoot: "_vendor/src/example.com/a",
+			Submodules: []Submodule{{Path: "third_party/s"}}},
+		{Canonical: "example.com/b", RepositoryRoot: "_vendor/src/example.com/b"},
+	}}
+	expectFindings := func(expected ...string) {
+		findings, err := checkVcsDirs(pkgs)
+		if err != nil {
+			test.Fatal(err)
+		}
+		files := []string{}
+		for _, f := range findings {
+			files = append(files, f.File)
+		}
+		if len(files) != len(expected) || (len(expected) > 0 && !reflect.DeepEqual(files, expected)) {
+			test.Errorf("expected findings about %v, got: %v", expected, files)
+		}
+	}
+	expectFindings("_vendor/src/example.com/a/sub/.hg")
+
+	os.RemoveAll("_vendor/src/example.com/a/sub")
+	err = os.MkdirAll("_vendor/src/example.com/.bzr", 0755)
+	if err != nil {
+		test.Fatal(err)
+	}
+	expectFindings("_vendor/src/example.com/.bzr")
+
+	os.RemoveAll("_vendor/src/example.com/.bzr")
+	expectFindings()
+
+	// A .git file outside of submodules is misplaced, like a .git dir.
+	writeTree(test, ".", map[string]string{
+		"_vendor/src/example.com/b/worktree/.git": "gitdir: " + gitdir + "\n",
+		"_vendor/src/example.com/b/notes/.git":    "not a git file\n",
+	})
+	expectFindings("_vendor/src/example.com/b/worktree/.git")
+}
+
+func Test_ignoredByGo(test *testing.T) {
+	cases := map[string]bool{
+		"main.go":                    false,
+		"foo/bar/baz.go":             false,
+		"foo/testdata/baz.go":        true,
+		"_examples/main.go":          true,
+		"foo/.hidden/baz.go":         true,
+		"foo/bar_test.go":            false,
+		"foo/_bar.go":                true,
+		"foo/not_testdata/a_test.go": false,
+	}
+	for file, expected := range cases {
+		if ignored := ignoredByGo(file); ignored != expected {
+			test.Errorf("ignoredByGo(%q): expected %v, got %v", file, expected, ignored)
+		}
+	}
+}
+
+func Test_readImports(test *testing.T) {
+	files := map[string]string{
+		"a.go":      "package a\n\nimport (\n\t\"os\"\n\tfoo \"example.com/foo\"\n\t_ `example.com/bar`\n)\n",
+		"broken.go": "package a\n\nimport (\n",
+	}
+	read := func(root, subpath string) (io.ReadCloser, error) {
+		data, found := files[subpath]
+		if !found {
+			return nil, &os.PathError{Op: "read", Path: subpath, Err: os.ErrNotExist}
+		}
+		return ioutil.NopCloser(strings.NewReader(data)), nil
+	}
+	cases := []struct {
+		file     string
+		expected []string
+		isErr    bool
+	}{
+		{"a.go", []string{"example.com/bar", "example.com/foo", "os"}, false},
+		{"missing.go", []string{}, false},
+		{"broken.go", nil, true},
+	}
+	for _, c := range cases {
+		imports, err := readImports(token.NewFileSet(), c.file, read)
+		if (err != nil) != c.isErr {
+			test.Errorf("%s: expected error: %v, got: %v", c.file, c.isErr, err)
+			continue
+		}
+		if !c.isErr && !reflect.DeepEqual(imports, c.expected) {
+			test.Errorf("%s: expected %q, got %q", c.file, c.expected, imports)
+		}
+	}
+}
+
+func Test_findStagedChanges(test *testing.T) {
+	dir, cleanup := testProject(test)
+	defer cleanup()
+	writeTree(test, dir, map[string]string{
+		"main.go":           "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println() }\n",
+		"removed.go":        "package main\n\nimport (\n\t\"os\"\n\t\"strings\"\n)\n",
+		"comment.go":        "package main\n\nimport \"os\"\n",
+		"plan9.go":          "//go:build plan9\n\npackage main\n",
+		"main_test.go":      "package main\n\nimport \"testing\"\n",
+		"testdata/a/a.go":   "package a\n",
+		"_examples/main.go": "package main\n",
+		"README":            "readme\n",
+	})
+	testRun(test, dir, "git", "add", "-A")
+	testRun(test, dir, "git", "commit", "-q", "-m", "first")
+
+	expect := func(note string, expected *StagedChanges) {
+		changes, err := findStagedChanges()
+		if err != nil {
+			test.Fatal(err)
+		}
+		sort.Strings(changes.Imports)
+		if !reflect.DeepEqual(changes, expected) {
+			test.Errorf("%s: expected %+v, got %+v", note, expected, changes)
+		}
+	}
+	expect("nothing staged", &StagedChanges{})
+
+	writeTree(test, dir, map[string]string{
+		"main.go":           "package main\n\nimport (\n\t\"fmt\"\n\t\"example.com/new\"\n)\n\nfunc main() { fmt.Println() }\n",
+		"new.go":            "package main\n\nimport \"example.com/other\"\n",
+		"removed.go":        "package main\n\nimport \"os\"\n",
+		"comment.go":        "package main\n\n// Only a comment changed.\nimport \"os\"\n",
+		"main_test.go":      "package main\n\nimport (\n\t\"testing\"\n\t\"example.com/testonly\"\n)\n",
+		"testdata/a/a.go":   "package a\n\nimport \"example.com/ignored\"\n",
+		"_examples/main.go": "package main\n\nimport \"example.com/ignored\"\n",
+		"README":            "changed\n",
+	})
+	// NOTE: build constraints are not evaluated, as the file may be built on
+	// other platforms listed in vendor.json.
+	writeTree(test, dir, map[string]string{"plan9.go": "//go:build plan9\n\npackage main\n\nimport \"example.com/plan9\"\n"})
+	// Unstaged files are not checked.
+	writeTree(test, dir, map[string]string{"unstaged.go": "package main\n\nimport \"example.com/unstaged\"\n"})
+	testRun(test, dir, "git", "add", "main.go", "new.go", "removed.go", "comment.go", "main_test.go", "testdata",
+		"_examples", "README", "plan9.go")
+	expect("imports", &StagedChanges{Imports: []string{"main.go", "main_test.go", "new.go", "plan9.go", "removed.go"}})
+	testRun(test, dir, "git", "commit", "-q", "-m", "second")
+
+	testRun(test, dir, "git", "rm", "-q", "new.go")
+	writeTree(test, dir, map[string]string{"vendor.json": "{}\n"})
+	testRun(test, dir, "git", "add", "vendor.json")
+	expect("deleted file and vendor.json", &StagedChanges{Vendor: true, Imports: []string{"new.go"}})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// baseContext is cancelled when vendo receives SIGINT or SIGTERM, which kills
+// all running Cmds created with Command.
+var baseContext, cancelBaseContext = context.WithCancel(context.Background())
+
+var cleanups = struct {
+	sync.Mutex
+	next  int
+	funcs map[int]func()
+}{funcs: map[int]func(){}}
+
+// exitMu is held while cleanups are run after a signal, so that the program
+// doesn't exit (see Exit) in the middle of them.
+var exitMu sync.Mutex
+
+// AddCleanup registers f to be run if vendo is interrupted with SIGINT or
+// SIGTERM, e.g. to restore working tree modified by GitStashUnstaged. The
+// returned function unregisters f; it must be called when f is run (or not
+// needed anymore) in normal flow of the program.
+//
+// NOTE: when f is run, Cmds created with Command are already cancelled; f must
+// use CommandContext(context.Background(), ...) instead.
+func AddCleanup(f func()) (remove func()) {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+	id := cleanups.next
+	cleanups.next++
+	cleanups.funcs[id] = f
+	return func() {
+		cleanups.Lock()
+		defer cleanups.Unlock()
+		delete(cleanups.funcs, id)
+	}
+}
+
+// backupFiles saves contents of files, and registers a cleanup (see
+// AddCleanup) restoring them if vendo is interrupted. Files missing now are
+// deleted on restore. The returned function unregisters the cleanup.
+func backupFiles(paths ...string) (remove func(), err error) {
+	type backup struct {
+		path   string
+		exists bool
+		data   []byte
+		mode   os.FileMode
+	}
+	backups := []backup{}
+	for _, path := range paths {
+		b := backup{path: path}
+		info, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		default:
+			b.exists, b.mode = true, info.Mode().Perm()
+			b.data, err = ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		backups = append(backups, b)
+	}
+	return AddCleanup(func() {
+		for _, b := range backups {
+			var err error
+			if !b.exists {
+				err = os.Remove(b.path)
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			} else {
+				// Write to a temporary file first, so that e.g. git's index is replaced atomically.
+				tmp := b.path + ".vendo-restore"
+				err = ioutil.WriteFile(tmp, b.data, b.mode)
+				if err == nil {
+					err = os.Rename(tmp, b.path)
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "vendo: cannot restore %s: %s\n", b.path, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "vendo: restored %s\n", b.path)
+			}
+		}
+	}), nil
+}
+
+// handleSignals starts a goroutine which, on SIGINT or SIGTERM, kills any
+// running commands, runs the registered cleanups in reverse order of
+// registration, and exits the program. A second signal exits immediately.
+func handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		exitMu.Lock()
+		go func() {
+			<-signals
+			fmt.Fprintln(os.Stderr, "vendo: interrupted again, exiting without cleanup")
+			os.Exit(ExitInternal)
+		}()
+		fmt.Fprintf(os.Stderr, "vendo: %s, cleaning up...\n", sig)
+		cancelBaseContext()
+
+		cleanups.Lock()
+		ids := []int{}
+		for id := range cleanups.funcs {
+			ids = append(ids, id)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+		funcs := []func(){}
+		for _, id := range ids {
+			funcs = append(funcs, cleanups.funcs[id])
+		}
+		cleanups.Unlock()
+		for _, f := range funcs {
+			f()
+		}
+		writeTrace()
+		os.Exit(ExitInternal)
+	}()
+}
+
+// Exit terminates the program with code. If cleanups are being run after a
+// signal, it waits until they're finished (and the program exits).
+func Exit(code int) {
+	exitMu.Lock()
+	writeTrace()
+	os.Exit(code)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_backupFiles(test *testing.T) {
+	dir, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	existing, missing := filepath.Join(dir, "index"), filepath.Join(dir, ".gitignore")
+	writeTree(test, dir, map[string]string{"index": "original"})
+
+	remove, err := backupFiles(existing, missing)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer remove()
+	writeTree(test, dir, map[string]string{"index": "modified", ".gitignore": "/\n"})
+
+	// Run the cleanups, as after a signal.
+	cleanups.Lock()
+	funcs := []func(){}
+	for _, f := range cleanups.funcs {
+		funcs = append(funcs, f)
+	}
+	cleanups.Unlock()
+	for _, f := range funcs {
+		f()
+	}
+
+	data, err := ioutil.ReadFile(existing)
+	if err != nil || string(data) != "original" {
+		test.Errorf("expected %s restored to %q, got %q, %v", existing, "original", data, err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		test.Errorf("expected %s deleted, got: %v", missing, err)
+	}
+}
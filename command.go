@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"sort"
 	"strings"
+	"time"
 )
 
 // stderr can be changed in tests to capture output of Cmd's methods.
@@ -16,6 +18,10 @@ var stderr io.Writer = os.Stderr
 // Verbose can be used to make all Cmds run with forced LogAlways.
 var Verbose = false
 
+// DefaultTimeout is the Timeout of Cmds created with Command. Zero means no
+// timeout.
+var DefaultTimeout time.Duration = 0
+
 // NewEnviron returns a clone of the original array of KEY=VALUE entries, with
 // entries from the patch array merged (overriding existing KEYs).
 //
@@ -72,16 +78,40 @@ const (
 	LogNever
 )
 
+// waitDelay is how long output of a command is read after it exits or is
+// killed, while any of its child processes keeps the output open.
+var waitDelay = 5 * time.Second
+
 type Cmd struct {
 	Cmd *exec.Cmd
 	LogMode
+	// When Context is cancelled, or Timeout (if non-zero) expires, the command
+	// is killed, together with all its child processes.
+	Context context.Context
+	Timeout time.Duration
+	// mutating is set by Mutating()
+	mutating bool
 }
 
+// Command creates a Cmd which is cancelled when vendo is interrupted (see
+// AddCleanup).
 func Command(command string, args ...string) *Cmd {
-	return &Cmd{
+	return CommandContext(baseContext, command, args...)
+}
+
+func CommandContext(ctx context.Context, command string, args ...string) *Cmd {
+	cmd := &Cmd{
 		Cmd:     exec.Command(command, args...),
 		LogMode: LogOnError,
+		Context: ctx,
+		Timeout: DefaultTimeout,
 	}
+	// Run the command in a separate process group, so that we can kill it
+	// together with any processes it started (e.g. `go get` runs git).
+	setProcessGroup(cmd.Cmd)
+	// Don't wait for output of any processes which survived the command (e.g. not in its process group) for long.
+	cmd.Cmd.WaitDelay = waitDelay
+	return cmd
 }
 
 // Append extends the cmd's argument list with args.
@@ -100,6 +130,13 @@ func (cmd *Cmd) Setenv(variables ...string) *Cmd {
 	return cmd
 }
 
+// WithTimeout changes time after which the command is killed. Zero means no
+// timeout.
+func (cmd *Cmd) WithTimeout(timeout time.Duration) *Cmd {
+	cmd.Timeout = timeout
+	return cmd
+}
+
 // LogAlways changes what is printed to os.Stderr (see const LogAlways).
 func (cmd *Cmd) LogAlways() *Cmd {
 	cmd.LogMode = LogAlways
@@ -119,10 +156,14 @@ func (cmd *Cmd) LogOnError() *Cmd {
 }
 
 func (cmd *Cmd) CombinedOutput() ([]byte, error) {
+	if cmd.mutating && DryRun {
+		cmd.plan()
+		return nil, nil
+	}
 	if cmd.LogMode == LogAlways || Verbose {
 		cmd.printCmdWithEnv()
 	}
-	out, err := cmd.Cmd.CombinedOutput()
+	out, err := cmd.run()
 	if err != nil {
 		if cmd.LogMode == LogOnError {
 			cmd.printCmdWithEnv()
@@ -135,6 +176,48 @@ func (cmd *Cmd) CombinedOutput() ([]byte, error) {
 	return out, nil
 }
 
+// run runs the command and returns its combined stdout+stderr output. If
+// cmd.Context is done, or cmd.Timeout expires, the whole process group of the
+// command is killed. Each run is recorded by the tracer (see Phase).
+func (cmd *Cmd) run() ([]byte, error) {
+	start := time.Now()
+	out, err := cmd.runWithContext()
+	traceCmd(cmd, start, len(out), err)
+	return out, err
+}
+
+func (cmd *Cmd) runWithContext() ([]byte, error) {
+	ctx := cmd.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+	out := &bytes.Buffer{}
+	cmd.Cmd.Stdout = out
+	cmd.Cmd.Stderr = out
+	err := cmd.Cmd.Start()
+	if err != nil {
+		return out.Bytes(), err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Cmd.Wait() }()
+	select {
+	case err = <-done:
+		return out.Bytes(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd.Cmd.Process)
+		<-done
+		if ctx.Err() == context.DeadlineExceeded {
+			return out.Bytes(), fmt.Errorf("%s: killed after timeout of %s", cmd.Cmd.Args[0], cmd.Timeout)
+		}
+		return out.Bytes(), fmt.Errorf("%s: %s", cmd.Cmd.Args[0], ctx.Err())
+	}
+}
+
 // OutputLines runs the command and returns trimmed stdout+stderr output split
 // into lines.
 func (cmd *Cmd) OutputLines() ([]string, error) {
@@ -185,30 +268,41 @@ func envToMap(env []string) map[string]string {
 	return m
 }
 
-func (cmd *Cmd) printCmdWithEnv() {
-	// Detect tweaks of environment variables.
-	// Note: this won't show changes done using os.Setenv()
+// envDiff returns sorted entries of env which differ from os.Environ(), and
+// "KEY=" entries for variables missing in env. Nil env means no changes.
+// Note: this won't show changes done using os.Setenv()
+func envDiff(env []string) []string {
 	diff := []string{}
-	if cmd.Cmd.Env != nil {
-		original := envToMap(os.Environ())
-		changed := envToMap(cmd.Cmd.Env)
-		for k, v := range changed {
-			if original[k] != v {
-				diff = append(diff, v+" ")
-			}
+	if env == nil {
+		return diff
+	}
+	original := envToMap(os.Environ())
+	changed := envToMap(env)
+	for k, v := range changed {
+		if original[k] != v {
+			diff = append(diff, v)
 		}
-		for k := range original {
-			_, found := changed[k]
-			if !found {
-				diff = append(diff, k+"= ")
-			}
+	}
+	for k := range original {
+		_, found := changed[k]
+		if !found {
+			diff = append(diff, k+"=")
 		}
 	}
 	sort.Strings(diff)
+	return diff
+}
+
+func (cmd *Cmd) printCmdWithEnv() {
+	// Detect tweaks of environment variables.
+	diff := ""
+	for _, entry := range envDiff(cmd.Cmd.Env) {
+		diff += entry + " "
+	}
 
 	// Example output:
 	//	# GOOS=windows GOARCH=amd64 go build .
 	fmt.Fprintf(stderr, "# %s%s\n",
-		strings.Join(diff, ""),
+		diff,
 		strings.Join(cmd.Cmd.Args, " "))
 }
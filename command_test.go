@@ -2,9 +2,13 @@ package main
 
 import (
 	"bytes"
+	"os/exec"
 	"reflect"
 	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_Command_LogModes(test *testing.T) {
@@ -220,3 +224,65 @@ func Test_Command_OutputLines_Many(test *testing.T) {
 			expected, lines)
 	}
 }
+
+func Test_Command_Timeout(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("needs sh")
+	}
+	// The background "sleep" would keep the output pipe open, if only the
+	// shell was killed.
+	start := time.Now()
+	err := Command("sh", "-c", "sleep 10 & sleep 10").
+		WithTimeout(100 * time.Millisecond).
+		LogNever().
+		DiscardOutput()
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		test.Errorf("expected timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		test.Errorf("expected command to be killed quickly, took %s", elapsed)
+	}
+}
+
+func Test_Command_WaitDelay(test *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		test.Skip("needs setsid")
+	}
+	defer func(delay time.Duration) { waitDelay = delay }(waitDelay)
+	waitDelay = 100 * time.Millisecond
+	// The "sleep" in a new session survives the shell, and keeps the output
+	// pipe open.
+	start := time.Now()
+	Command("sh", "-c", "setsid sleep 10 &").
+		LogNever().
+		DiscardOutput()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		test.Errorf("expected command to finish quickly, took %s", elapsed)
+	}
+}
+
+func Test_Command_Trace(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("needs sh")
+	}
+	endOuter := Phase("outer")
+	endInner := Phase("inner")
+	Command("sh", "-c", "echo hello; exit 3").
+		Setenv("VENDO_TEST=1").
+		LogNever().
+		DiscardOutput()
+	endInner()
+	endOuter()
+
+	tracer.Lock()
+	traced := tracer.Commands[len(tracer.Commands)-1]
+	phase := tracer.phases["outer/inner"]
+	tracer.Unlock()
+	if traced.Phase != "outer/inner" || traced.ExitStatus != 3 || traced.OutputBytes != len("hello\n") ||
+		!reflect.DeepEqual(traced.Env, []string{"VENDO_TEST=1"}) {
+		test.Errorf("unexpected trace of command: %+v", traced)
+	}
+	if phase == nil || phase.Commands != 1 {
+		test.Errorf("unexpected trace of phase: %+v", phase)
+	}
+}
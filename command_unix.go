@@ -0,0 +1,46 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup runs cmd in a new process group, so that it can be killed
+// together with all processes it started (see killProcessGroup).
+//
+// NOTE: a process group other than vendo's is in the background from the
+// terminal's perspective, so a command reading a password from /dev/tty would
+// be stopped with SIGTTIN. Such prompts are disabled instead (see
+// disableTerminalPrompts).
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills process p, and all processes in its process group
+// (see setProcessGroup).
+func killProcessGroup(p *os.Process) {
+	// Negative pid means the whole process group.
+	err := syscall.Kill(-p.Pid, syscall.SIGKILL)
+	if err != nil {
+		p.Kill()
+	}
+}
+
+// disableTerminalPrompts makes git and ssh (started by git) fail instead of
+// prompting on the terminal for credentials or a passphrase, unless user
+// configured them otherwise. Credential helpers and ssh-agent still work.
+func disableTerminalPrompts() {
+	if _, found := os.LookupEnv("GIT_TERMINAL_PROMPT"); !found {
+		os.Setenv("GIT_TERMINAL_PROMPT", "0")
+	}
+	if os.Getenv("GIT_SSH") == "" && os.Getenv("GIT_SSH_COMMAND") == "" {
+		os.Setenv("GIT_SSH_COMMAND", "ssh -o BatchMode=yes")
+	}
+}
@@ -28,7 +28,7 @@ func wrapRun(run func(cmd *cobra.Command, args []string) error) func(cmd *cobra.
 		err := run(cmd, args)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
+			Exit(1)
 		}
 	}
 }
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// CacheDir is the directory in git's metadata dir (usually .git/), where
+// vendo caches results of crawling the dependencies.
+const CacheDir = "vendo-cache"
+
+const (
+	// crawlCacheVersion must be incremented on any change in format of the
+	// cached Dependencies, or in the way they are crawled.
+	crawlCacheVersion = 1
+	// crawlCacheMaxEntries is the number of cache entries kept; least
+	// recently used ones are removed above it.
+	crawlCacheMaxEntries = 32
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "clear-cache",
+		Short: "remove cached results of crawling the dependencies",
+		Long: fmt.Sprintf(
+			`Clear-cache removes the directory %s/ from project's git metadata dir
+(usually .git/), where 'check', 'why' and 'graph' cache the import graphs of the
+project, keyed by the git tree of the project (including %s/), Go version,
+GOPATH and platforms.
+
+The cache is used only if no *.go files are modified or untracked in git, but
+*.go files ignored by git are not taken into account. Clear the cache if you
+modified any of them and the results look stale.`,
+			CacheDir, VendorPath),
+	}
+	cmd.Flags().BoolVar(&DryRun, "dry-run", false, dryRunUsage)
+	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		return ClearCache()
+	})
+	cmds.AddCommand(cmd)
+}
+
+func ClearCache() error {
+	dir, err := findCacheDir()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "# rm -rf %s\n", dir)
+	return removeAll(dir)
+}
+
+// findCacheDir returns path of CacheDir, also if the project is a git worktree
+// or submodule (with .git file instead of directory).
+func findCacheDir() (string, error) {
+	return Command("git", "rev-parse", "--git-path", CacheDir).
+		OutputOneLine()
+}
+
+// crawlCacheKey identifies all inputs of crawlDependencies.
+type crawlCacheKey struct {
+	Version int `json:"version"`
+	// Tree is the git tree hash of the index ("staging area") of the project,
+	// including _vendor/.
+	Tree      string     `json:"tree"`
+	GoVersion string     `json:"goVersion"`
+	Goroot    string     `json:"goroot"`
+	Dir       string     `json:"dir"`
+	Gopath    string     `json:"gopath"`
+	Platforms []Platform `json:"platforms"`
+}
+
+type crawlCacheEntry struct {
+	Key  crawlCacheKey `json:"key"`
+	Deps *Dependencies `json:"deps"`
+}
+
+// crawlCache is a single entry in CacheDir. Nil crawlCache means that the
+// cache cannot be used.
+type crawlCache struct {
+	key  crawlCacheKey
+	path string
+}
+
+// crawlDependenciesCached is crawlDependencies, but its results are cached
+// in CacheDir. It's only safe to use if the project's files are not modified
+// in the meantime by vendo (e.g. not in 'recreate').
+func crawlDependenciesCached(gopath string, platforms []Platform) (*Dependencies, error) {
+	cache := openCrawlCache(gopath, platforms)
+	if deps := cache.load(); deps != nil {
+		return deps, nil
+	}
+	deps, err := crawlDependencies(gopath, platforms)
+	if err != nil {
+		return nil, err
+	}
+	cache.store(deps)
+	return deps, nil
+}
+
+// openCrawlCache returns the cache entry for project in current dir, or nil
+// if the cache cannot be used, e.g. if any *.go files differ from git's index.
+func openCrawlCache(gopath string, platforms []Platform) *crawlCache {
+	defer Phase("cache key")()
+	dirty, err := Command("git", "ls-files", "-z", "--modified", "--deleted", "--others", "--exclude-standard", "--", "*.go").
+		LogNever().
+		CombinedOutput()
+	if err != nil || len(dirty) > 0 {
+		return nil
+	}
+	// NOTE: write-tree fails if there are unmerged files.
+	tree, err := Command("git", "write-tree").
+		LogNever().
+		OutputOneLine()
+	if err != nil {
+		return nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	cacheDir, err := findCacheDir()
+	if err != nil {
+		return nil
+	}
+	key := crawlCacheKey{
+		Version:   crawlCacheVersion,
+		Tree:      tree,
+		GoVersion: runtime.Version(),
+		Goroot:    build.Default.GOROOT,
+		Dir:       dir,
+		Gopath:    gopath,
+		Platforms: platforms,
+	}
+	buf, err := json.Marshal(key)
+	if err != nil {
+		return nil
+	}
+	hash := sha1.Sum(buf)
+	return &crawlCache{
+		key:  key,
+		path: filepath.Join(cacheDir, hex.EncodeToString(hash[:])+".json"),
+	}
+}
+
+// load returns the cached Dependencies, or nil if not found.
+func (c *crawlCache) load() *Dependencies {
+	if c == nil {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil
+	}
+	entry := crawlCacheEntry{}
+	err = json.Unmarshal(buf, &entry)
+	if err != nil || !reflect.DeepEqual(entry.Key, c.key) || entry.Deps == nil {
+		return nil
+	}
+	// Mark as recently used.
+	now := time.Now()
+	os.Chtimes(c.path, now, now)
+	return entry.Deps
+}
+
+// store saves deps in the cache, unless they depend on contents of GOPATH
+// outside _vendor/, which is not covered by the key.
+func (c *crawlCache) store(deps *Dependencies) {
+	if c == nil {
+		return
+	}
+	vendorAbsPath, err := getVendorAbsPath()
+	if err != nil {
+		return
+	}
+	for _, g := range deps.Graphs {
+		for _, pkg := range g.Packages {
+			switch {
+			case pkg.Standard, pkg.Project:
+			case pkg.Root == vendorAbsPath:
+			case pkg.Dir == "" && c.key.Gopath == vendorAbsPath:
+			default:
+				// Package found outside _vendor/, or may be found there
+				// after `go get`.
+				return
+			}
+		}
+	}
+
+	err = c.write(crawlCacheEntry{Key: c.key, Deps: deps})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "# cannot write to cache: %s\n", err)
+		return
+	}
+	evictCacheEntries(filepath.Dir(c.path), crawlCacheMaxEntries)
+}
+
+// write saves entry via a temporary file, so that a concurrently running vendo
+// never reads a partially written entry.
+func (c *crawlCache) write(entry crawlCacheEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), "tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// evictCacheEntries removes the least recently used entries from dir, above
+// max.
+func evictCacheEntries(dir string, max int) {
+	all, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	infos := []os.FileInfo{}
+	for _, info := range all {
+		if filepath.Ext(info.Name()) == ".json" {
+			infos = append(infos, info)
+		}
+	}
+	if len(infos) <= max {
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos[max:] {
+		os.Remove(filepath.Join(dir, info.Name()))
+	}
+}
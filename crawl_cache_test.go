@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_evictCacheEntries(test *testing.T) {
+	dir, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ages := map[string]time.Duration{
+		"oldest.json": 3 * time.Hour,
+		"middle.json": 2 * time.Hour,
+		"newest.json": 1 * time.Hour,
+		"tmp-123":     4 * time.Hour,
+	}
+	for name, age := range ages {
+		path := filepath.Join(dir, name)
+		err = ioutil.WriteFile(path, []byte("{}"), 0644)
+		if err != nil {
+			test.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	evictCacheEntries(dir, 2)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	expected := []string{"middle.json", "newest.json", "tmp-123"}
+	if !reflect.DeepEqual(names, expected) {
+		test.Errorf("expected %q, got %q", expected, names)
+	}
+}
+
+func Test_openCrawlCache(test *testing.T) {
+	dir, cleanup := testProject(test)
+	defer cleanup()
+	writeTree(test, dir, map[string]string{
+		"main.go":               "package main\n",
+		"_vendor/src/x/x.go":    "package x\n",
+		"_vendor/src/x/LICENSE": "MIT\n",
+	})
+	testRun(test, dir, "git", "add", "-A")
+	gopath := filepath.Join(dir, "_vendor")
+	platforms := []Platform{{"linux", "amd64"}}
+	deps := &Dependencies{Project: "example.com/proj"}
+	cache := openCrawlCache(gopath, platforms)
+	if cache == nil {
+		test.Fatal("expected cache for clean index")
+	}
+	cache.store(deps)
+
+	expectCached := func(note string, platforms []Platform, expected bool) {
+		cached := openCrawlCache(gopath, platforms).load()
+		switch {
+		case expected && !reflect.DeepEqual(cached, deps):
+			test.Errorf("%s: expected cache hit %+v, got %+v", note, deps, cached)
+		case !expected && cached != nil:
+			test.Errorf("%s: expected cache miss, got %+v", note, cached)
+		}
+	}
+	change := func(files map[string]string, args ...string) {
+		writeTree(test, dir, files)
+		if len(args) > 0 {
+			testRun(test, dir, args...)
+		}
+	}
+	expectCached("unchanged", platforms, true)
+	expectCached("other platforms", []Platform{{"linux", "amd64"}, {"windows", "amd64"}}, false)
+	change(map[string]string{"README": "readme\n"})
+	expectCached("untracked non-Go file", platforms, true)
+
+	change(map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	expectCached("modified .go file", platforms, false)
+	testRun(test, dir, "git", "add", "main.go")
+	expectCached("staged .go file", platforms, false)
+	change(map[string]string{"main.go": "package main\n"}, "git", "add", "main.go")
+	expectCached("reverted .go file", platforms, true)
+
+	change(map[string]string{"sub/sub.go": "package sub\n"})
+	expectCached("untracked .go file", platforms, false)
+	err := os.RemoveAll(filepath.Join(dir, "sub"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	expectCached("removed untracked .go file", platforms, true)
+
+	change(map[string]string{"_vendor/src/x/LICENSE": "BSD\n"}, "git", "add", "-A")
+	expectCached("staged change in _vendor/", platforms, false)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DryRun makes commands modifying the project (e.g. 'vendo recreate'), or
+// vendo's cache and mirrors, only print a plan of what they would do.
+// Read-only queries (e.g. `go list`, `git status`) are still run.
+var DryRun = false
+
+const dryRunUsage = "only print what would be done, without modifying anything"
+
+// planned prints description of an operation, which would modify the project
+// if not in DryRun mode.
+func planned(format string, args ...interface{}) {
+	fmt.Printf("would "+format+"\n", args...)
+}
+
+// Mutating marks cmd as modifying the project. In DryRun mode, such cmd is not
+// run; it's only printed, and returns empty output.
+func (cmd *Cmd) Mutating() *Cmd {
+	cmd.mutating = true
+	return cmd
+}
+
+func (cmd *Cmd) plan() {
+	line := strings.Join(cmd.Cmd.Args, " ")
+	if cmd.Cmd.Dir != "" {
+		line = "cd " + cmd.Cmd.Dir + " ; " + line
+	}
+	planned("run: %s", line)
+}
+
+// removeFile is os.Remove, which is only planned in DryRun mode. Missing file
+// is not an error.
+func removeFile(path string) error {
+	if DryRun {
+		if _, err := os.Lstat(path); err == nil {
+			planned("delete file: %s", path)
+		}
+		return nil
+	}
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// removeAll is os.RemoveAll, which is only planned in DryRun mode.
+func removeAll(path string) error {
+	if DryRun {
+		planned("delete directory: %s", path)
+		return nil
+	}
+	return os.RemoveAll(path)
+}
+
+// writeFile is ioutil.WriteFile, which is only planned in DryRun mode.
+func writeFile(path string, data []byte) error {
+	if DryRun {
+		planned("write file: %s (%d bytes)", path, len(data))
+		return nil
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// planVendorFileChanges prints packages added, removed, or with changed
+// revision in vendor.json.
+func planVendorFileChanges(old, new *VendorFile) {
+	oldByImp, newByImp := old.ByCanonical(), new.ByCanonical()
+	imps := set{}
+	for imp := range oldByImp {
+		imps.Add(imp)
+	}
+	for imp := range newByImp {
+		imps.Add(imp)
+	}
+	sorted := imps.ToSlice()
+	sort.Strings(sorted)
+	for _, imp := range sorted {
+		oldPkg, newPkg := oldByImp[imp], newByImp[imp]
+		switch {
+		case oldPkg == nil:
+			planned("add to %s: %s %s %s", JsonPath, imp, newPkg.RepositoryRoot, newPkg.Revision)
+		case newPkg == nil:
+			planned("remove from %s: %s %s %s", JsonPath, imp, oldPkg.RepositoryRoot, oldPkg.Revision)
+		case oldPkg.Revision != newPkg.Revision || oldPkg.RepositoryRoot != newPkg.RepositoryRoot:
+			planned("change in %s: %s %s %s -> %s %s", JsonPath, imp,
+				oldPkg.RepositoryRoot, oldPkg.Revision, newPkg.RepositoryRoot, newPkg.Revision)
+		}
+	}
+}
+
+// plannedClones maps import paths of packages which would be cloned to
+// _vendor/ in DryRun mode, to their original directories in GOPATH.
+type plannedClones map[string]string
+
+// Find returns directory from which info about repository of package imp
+// should be read: either its directory in _vendor/, or, if the package was
+// not really cloned because of DryRun, its original directory. Repository
+// root returned by vcsList.FindRoot in the latter must be mapped by
+// VendorRoot.
+func (p plannedClones) Find(imp string) (dir string, cloned bool) {
+	if orig, found := p[imp]; found {
+		return orig, true
+	}
+	return filepath.Join(VendorPath, "src", imp), false
+}
+
+// VendorRoot converts repository root found in original directory of package
+// imp to the path it would have in _vendor/.
+func (p plannedClones) VendorRoot(imp, origRoot string) (string, error) {
+	rel, err := filepath.Rel(p[imp], origRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(VendorPath, "src", imp, rel), nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 // Exist is utility struct for easy checking existence of multiple files and
@@ -42,3 +43,24 @@ func (e Exist) File(path string) Exist {
 	}
 	return Exist{}
 }
+
+// GitDir verifies that path is git metadata: either a directory, or a file
+// pointing to it (in worktrees created with `git worktree add`, and in
+// checkouts of submodules).
+func (e Exist) GitDir(path string) Exist {
+	if e.Err != nil {
+		return e
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Exist{err}
+	}
+	if info.IsDir() {
+		return Exist{}
+	}
+	_, _, err = git{}.metadataDirs(filepath.Dir(path))
+	if err != nil {
+		return Exist{fmt.Errorf("not a git directory: %s: %s", path, err)}
+	}
+	return Exist{}
+}
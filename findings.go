@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// IDs of checks run by 'vendo check'.
+const (
+	CheckIdConsistency  = "consistency"
+	CheckIdDependencies = "dependencies"
+	CheckIdPatched      = "patched"
+	CheckIdLicenses     = "licenses"
+	// CheckIdInternal is used for errors which prevented a check from
+	// completing.
+	CheckIdInternal = "internal"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a single problem detected by one of the checks.
+type Finding struct {
+	// Check is the ID of the check which reported the problem (see
+	// CheckIdConsistency etc.).
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	// Package, RepositoryRoot and File locate the problem, if applicable.
+	Package        string `json:"package,omitempty"`
+	RepositoryRoot string `json:"repositoryRoot,omitempty"`
+	File           string `json:"file,omitempty"`
+	Message        string `json:"message"`
+	// Fix is a suggested way to fix the problem, for humans.
+	Fix string `json:"fix,omitempty"`
+}
+
+// Subject returns the most specific location of the finding.
+func (f *Finding) Subject() string {
+	switch {
+	case f.File != "":
+		return f.File
+	case f.RepositoryRoot != "":
+		return f.RepositoryRoot
+	case f.Package != "":
+		return f.Package
+	}
+	return f.Check
+}
+
+type Findings []*Finding
+
+// Add appends a new error finding to f, and returns it, so that optional
+// fields can be filled by caller.
+func (f *Findings) Add(check, format string, args ...interface{}) *Finding {
+	finding := &Finding{
+		Check:    check,
+		Severity: SeverityError,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	*f = append(*f, finding)
+	return finding
+}
+
+// Exit codes of 'vendo check'. If problems of more than one class are found,
+// the exit code is a bitwise OR of their codes.
+const (
+	ExitInternal     = 1 // a check could not be completed
+	ExitConsistency  = 2
+	ExitDependencies = 4
+	ExitPatched      = 8
+	ExitLicenses     = 16
+)
+
+var checkExitCodes = map[string]int{
+	CheckIdConsistency:  ExitConsistency,
+	CheckIdDependencies: ExitDependencies,
+	CheckIdPatched:      ExitPatched,
+	CheckIdLicenses:     ExitLicenses,
+}
+
+// ExitCode returns exit code describing classes of error findings in f, or 0
+// if there are none.
+func (f Findings) ExitCode() int {
+	code := 0
+	for _, finding := range f {
+		if finding.Severity != SeverityError {
+			continue
+		}
+		if c, found := checkExitCodes[finding.Check]; found {
+			code |= c
+		} else {
+			code |= ExitInternal
+		}
+	}
+	return code
+}
+
+func (f Findings) WriteText(w io.Writer) error {
+	for _, finding := range f {
+		message := strings.Replace(finding.Message, "\n", "\n\t", -1)
+		_, err := fmt.Fprintf(w, "%s: [%s] %s: %s\n", finding.Severity, finding.Check, finding.Subject(), message)
+		if err != nil {
+			return err
+		}
+		if finding.Fix != "" {
+			_, err = fmt.Fprintf(w, "\tfix: %s\n", strings.Replace(finding.Fix, "\n", "\n\t", -1))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f Findings) WriteJson(w io.Writer) error {
+	if f == nil {
+		f = Findings{}
+	}
+	buf, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", buf)
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJunit writes f as JUnit XML report, with a test suite for each of
+// checks. Checks without findings are reported as a single passed test case.
+// Warnings are reported as passed test cases, with the message in
+// "system-out".
+func (f Findings) WriteJunit(w io.Writer, checks []string) error {
+	report := junitTestSuites{Name: "vendo check"}
+	report.add(f.junitSuites("", checks))
+	return report.write(w)
+}
+
+// junitSuites returns a test suite for each of checks, with names prefixed
+// by prefix.
+func (f Findings) junitSuites(prefix string, checks []string) []junitTestSuite {
+	suites := []junitTestSuite{}
+	for _, check := range checks {
+		suite := junitTestSuite{Name: prefix + check}
+		for _, finding := range f {
+			if finding.Check != check {
+				continue
+			}
+			text := finding.Message
+			if finding.Fix != "" {
+				text += "\nfix: " + finding.Fix
+			}
+			testCase := junitTestCase{
+				ClassName: "vendo." + check,
+				Name:      finding.Subject(),
+			}
+			if finding.Severity == SeverityError {
+				testCase.Failure = &junitFailure{
+					Message: strings.SplitN(finding.Message, "\n", 2)[0],
+					Type:    string(finding.Severity),
+					Text:    text,
+				}
+				suite.Failures++
+			} else {
+				testCase.SystemOut = text
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		if len(suite.TestCases) == 0 {
+			suite.TestCases = append(suite.TestCases, junitTestCase{ClassName: "vendo." + check, Name: check})
+		}
+		suite.Tests = len(suite.TestCases)
+		suites = append(suites, suite)
+	}
+	return suites
+}
+
+func (r *junitTestSuites) add(suites []junitTestSuite) {
+	for _, suite := range suites {
+		r.Tests += suite.Tests
+		r.Failures += suite.Failures
+		r.Suites = append(r.Suites, suite)
+	}
+}
+
+func (r *junitTestSuites) write(w io.Writer) error {
+	buf, err := xml.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, buf)
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_Findings_ExitCode(test *testing.T) {
+	findings := Findings{}
+	if code := findings.ExitCode(); code != 0 {
+		test.Errorf("expected 0 for no findings, got %d", code)
+	}
+	findings.Add(CheckIdLicenses, "foo").Severity = SeverityWarning
+	if code := findings.ExitCode(); code != 0 {
+		test.Errorf("expected 0 for warnings only, got %d", code)
+	}
+	findings.Add(CheckIdConsistency, "foo")
+	findings.Add(CheckIdPatched, "bar")
+	findings.Add(CheckIdPatched, "baz")
+	findings.Add(CheckIdInternal, "bang")
+	expected := ExitConsistency | ExitPatched | ExitInternal
+	if code := findings.ExitCode(); code != expected {
+		test.Errorf("expected %d, got %d", expected, code)
+	}
+}
+
+func Test_Findings_WriteJunit(test *testing.T) {
+	findings := Findings{}
+	f := findings.Add(CheckIdPatched, "local patch detected")
+	f.RepositoryRoot = "_vendor/src/example.com/a"
+	buf := bytes.Buffer{}
+	err := findings.WriteJunit(&buf, []string{CheckIdConsistency, CheckIdPatched})
+	if err != nil {
+		test.Fatal(err)
+	}
+	out := buf.String()
+	for _, expected := range []string{
+		`<testsuites name="vendo check" tests="2" failures="1">`,
+		`<testsuite name="consistency" tests="1" failures="0">`,
+		`<testcase classname="vendo.consistency" name="consistency"></testcase>`,
+		`<testcase classname="vendo.patched" name="_vendor/src/example.com/a">`,
+		`<failure message="local patch detected" type="error">local patch detected</failure>`,
+	} {
+		if !strings.Contains(out, expected) {
+			test.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+}
@@ -2,18 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// noUnstagedChanges is set when files on disk are known to match git's
+// staging area (see CheckRange), so GitStashUnstaged doesn't need to run `git
+// stash`, which is noisy and fails before the first commit.
+var noUnstagedChanges = false
+
 type GitStasher struct {
-	stashCreated bool
+	mu            sync.Mutex
+	stashCreated  bool
+	removeCleanup func()
 }
 
 // GitStashUnstaged hides from disk all unstaged changes (but doesn't touch
@@ -58,6 +68,9 @@ func GitStashUnstaged(comment string) (*GitStasher, error) {
 	// c) don't call `git stash` at all; operate on working dir contents;
 	//    * (-) this makes most of vendo-check-... non-robust;
 	//    * (+) fast to implement;
+	if noUnstagedChanges {
+		return &GitStasher{stashCreated: false}, nil
+	}
 	cmd := Command("git", "stash", "save", "--keep-index", comment)
 	lines, err := cmd.
 		LogAlways().
@@ -67,7 +80,10 @@ func GitStashUnstaged(comment string) (*GitStasher, error) {
 	}
 	switch {
 	case len(lines) > 0 && strings.HasSuffix(lines[0], ": "+comment):
-		return &GitStasher{stashCreated: true}, nil
+		stasher := &GitStasher{stashCreated: true}
+		// Make sure unstaged changes are restored also if we're interrupted.
+		stasher.removeCleanup = AddCleanup(stasher.Unstash)
+		return stasher, nil
 	case len(lines) == 1 && lines[0] == "No local changes to save":
 		return &GitStasher{stashCreated: false}, nil
 	}
@@ -76,11 +92,18 @@ func GitStashUnstaged(comment string) (*GitStasher, error) {
 		strings.Join(lines, "\n"))
 }
 
+// Unstash restores changes hidden by GitStashUnstaged. It's safe to call it
+// more than once, also concurrently (e.g. from a cleanup after a signal).
 func (g *GitStasher) Unstash() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	if !g.stashCreated {
 		return
 	}
-	err := Command("git", "stash", "pop", "--quiet").
+	g.stashCreated = false
+	g.removeCleanup()
+	// NOTE: must not be cancelled by a signal, see AddCleanup.
+	err := CommandContext(context.Background(), "git", "stash", "pop", "--quiet").
 		LogAlways().
 		DiscardOutput()
 	if err != nil {
@@ -234,3 +257,107 @@ func (g git) ReadHead(root, subpath string) (io.ReadCloser, error) {
 type nopCloser struct{ io.Reader }
 
 func (nopCloser) Close() error { return nil }
+
+// gitFilterIgnored returns those of files (slash-separated, relative to main
+// repo root) which are not ignored by main repo's .gitignore rules. In DryRun
+// mode, rules from _vendor/.gitignore are skipped, as the file would be
+// deleted by forget().
+func gitFilterIgnored(files []string) ([]string, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	cmd := Command("git", "check-ignore", "-z", "-v", "--stdin").LogNever()
+	cmd.Cmd.Stdin = strings.NewReader(strings.Join(files, "\x00") + "\x00")
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// Exit status 1 means that none of the files is ignored.
+		return files, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error running git check-ignore: %s", err)
+	}
+	// With "-v", each ignored file is printed with the matching rule, as:
+	// SOURCE NUL LINENUM NUL PATTERN NUL PATHNAME NUL
+	// NOTE: files matched by a negated "!pattern" rule (i.e. re-included) are printed too.
+	ignored := set{}
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for i := 0; i+3 < len(fields); i += 4 {
+		if DryRun && fields[i] == GitignorePath {
+			continue
+		}
+		if strings.HasPrefix(fields[i+2], "!") {
+			continue
+		}
+		ignored.Add(fields[i+3])
+	}
+	result := []string{}
+	for _, file := range files {
+		if _, found := ignored[file]; !found {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
+
+// gitAddFiles adds files (slash-separated, relative to main repo root) to
+// git's "staging area" (index). Contrary to `git add`, it works also for files
+// inside nested repositories (e.g. a cloned repo in _vendor/ with its own .git
+// subdir), which newer versions of git refuse to add (or add as gitlinks).
+func gitAddFiles(files []string) error {
+	// TODO: file names with "\n" are not supported by `git hash-object --stdin-paths`
+	regular, symlinks := []string{}, []string{}
+	modes := map[string]string{}
+	for _, file := range files {
+		info, err := os.Lstat(filepath.FromSlash(file))
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			symlinks = append(symlinks, file)
+			modes[file] = "120000"
+		case info.Mode()&0111 != 0:
+			regular = append(regular, file)
+			modes[file] = "100755"
+		default:
+			regular = append(regular, file)
+			modes[file] = "100644"
+		}
+	}
+
+	hashes := map[string]string{}
+	if len(regular) > 0 {
+		cmd := Command("git", "hash-object", "-w", "--stdin-paths").Mutating()
+		cmd.Cmd.Stdin = strings.NewReader(strings.Join(regular, "\n") + "\n")
+		lines, err := cmd.OutputLines()
+		if err != nil {
+			return err
+		}
+		if len(lines) != len(regular) {
+			return fmt.Errorf("expected %d lines of output from git hash-object, got %d", len(regular), len(lines))
+		}
+		for i, file := range regular {
+			hashes[file] = lines[i]
+		}
+	}
+	for _, file := range symlinks {
+		target, err := os.Readlink(filepath.FromSlash(file))
+		if err != nil {
+			return err
+		}
+		cmd := Command("git", "hash-object", "-w", "--stdin").Mutating()
+		cmd.Cmd.Stdin = strings.NewReader(target)
+		hashes[file], err = cmd.OutputOneLine()
+		if err != nil {
+			return err
+		}
+	}
+
+	info := &bytes.Buffer{}
+	for _, file := range files {
+		fmt.Fprintf(info, "%s %s\t%s\x00", modes[file], hashes[file], file)
+	}
+	cmd := Command("git", "update-index", "--add", "-z", "--index-info").Mutating()
+	cmd.Cmd.Stdin = info
+	return cmd.DiscardOutput()
+}
@@ -1,6 +1,52 @@
 package main
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testGitEnv isolates git from user's configuration in tests.
+var testGitEnv = []string{"GIT_CONFIG_NOSYSTEM=1", "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+	"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com"}
+
+// testRun runs a command in dir, with testGitEnv, and returns its trimmed
+// output. Errors fail the test.
+func testRun(test *testing.T, dir string, args ...string) string {
+	cmd := Command(args[0], args[1:]...).Setenv(testGitEnv...)
+	cmd.Cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		test.Fatalf("%s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// testProject creates a temporary directory with an empty git repository and
+// makes it the current directory. The returned function restores the current
+// directory and removes the project.
+func testProject(test *testing.T) (dir string, cleanup func()) {
+	requireVcs(test, "git")
+	dir, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	testRun(test, dir, "git", "-c", "init.defaultBranch=master", "init", "-q")
+	err = os.Chdir(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return dir, func() {
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	}
+}
 
 func Test_git_parseFilename(test *testing.T) {
 	cases := []struct{ input, expFilename, expRest, expError string }{
@@ -38,3 +84,22 @@ func Test_git_parseFilename(test *testing.T) {
 		}
 	}
 }
+
+func Test_gitFilterIgnored(test *testing.T) {
+	_, cleanup := testProject(test)
+	defer cleanup()
+	writeTree(test, ".", map[string]string{
+		".gitignore": "*.log\n!keep.log\n",
+		"a.go":       "package a\n",
+		"a.log":      "",
+		"keep.log":   "",
+	})
+	files, err := gitFilterIgnored([]string{"a.go", "a.log", "keep.log"})
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected := []string{"a.go", "keep.log"}
+	if !reflect.DeepEqual(files, expected) {
+		test.Errorf("expected %q, got %q", expected, files)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "export the import graph of project and vendored packages",
+		Long: fmt.Sprintf(
+			`Graph crawls the dependencies of current project the same way as 'recreate',
+and prints the import graph between project packages and vendored packages
+(standard library is omitted) in Graphviz DOT or JSON format. Nodes are
+annotated with information from %s: revision, revision time, patched
+state, and platforms on which the package is needed.
+
+Example:
+  vendo graph --repos | dot -Tsvg > deps.svg`,
+			JsonPath),
+	}
+	var (
+		format        = cmd.Flags().String("format", "dot", "output format: dot or json")
+		repos         = cmd.Flags().Bool("repos", false, "collapse packages to their repositoryRoot (and project packages to the project)")
+		platformsList = cmd.Flags().String("platforms", "", "format: OS_ARCH,OS_ARCH2[,...]")
+	)
+	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		platforms, err := parsePlatforms(*platformsList)
+		if err != nil {
+			// TODO(mateuszc): subcmd usage
+			return err
+		}
+		export, err := BuildGraphExport(platforms, *repos)
+		if err != nil {
+			return err
+		}
+		switch *format {
+		case "dot":
+			return export.WriteDot(os.Stdout)
+		case "json":
+			return export.WriteJson(os.Stdout)
+		}
+		return fmt.Errorf("unknown format %q, expected: dot or json", *format)
+	})
+	cmds.AddCommand(cmd)
+}
+
+// GraphExport is the import graph of the project merged for all platforms,
+// in a form suitable for export.
+type GraphExport struct {
+	// Platforms lists all platforms for which the graph was crawled.
+	Platforms []Platform         `json:"platforms"`
+	Nodes     []*GraphExportNode `json:"nodes"`
+	Edges     []*GraphExportEdge `json:"edges"`
+}
+
+type GraphExportNode struct {
+	// ID is an import path, or a repositoryRoot converted to import path if
+	// the graph is collapsed to repositories.
+	ID             string     `json:"id"`
+	Project        bool       `json:"project,omitempty"`
+	RepositoryRoot string     `json:"repositoryRoot,omitempty"`
+	Revision       string     `json:"revision,omitempty"`
+	RevisionTime   string     `json:"revisionTime,omitempty"`
+	Scope          Scope      `json:"scope,omitempty"`
+	Platforms      []Platform `json:"platforms,omitempty"`
+	// Patched is one of: "clean", "patched", or "unknown" (when there's no
+	// .git/.hg/.bzr metadata to compare with).
+	Patched string `json:"patched,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	// Source is the fork from which the repository is vendored, if any.
+	Source string `json:"source,omitempty"`
+}
+
+type GraphExportEdge struct {
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Platforms []Platform `json:"platforms"`
+}
+
+// BuildGraphExport crawls project's dependencies and merges the resulting
+// graphs. If platforms is empty, they are read from vendor.json. If repos is
+// true, packages are collapsed to their repository roots.
+func BuildGraphExport(platforms []Platform, repos bool) (*GraphExport, error) {
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(platforms) == 0 {
+		platforms = pkgs.Platforms
+		if len(platforms) == 0 {
+			return nil, fmt.Errorf(`empty list of platforms (you must set flag "-platforms" or %s field "platforms")`, JsonPath)
+		}
+	}
+
+	// Use the same GOPATH as 'recreate': GOPATH=$PWD/_vendor:$GOPATH
+	vendorAbsPath, err := getVendorAbsPath()
+	if err != nil {
+		return nil, err
+	}
+	gopath := vendorAbsPath + string(filepath.ListSeparator) + os.Getenv("GOPATH")
+	deps, err := crawlDependenciesCached(gopath, platforms)
+	if err != nil {
+		return nil, err
+	}
+	return newGraphExport(pkgs, deps, platforms, repos)
+}
+
+// newGraphExport merges the graphs of deps, crawled for platforms, and
+// annotates the nodes with information from pkgs. If repos is true, packages
+// are collapsed to their repository roots.
+func newGraphExport(pkgs *VendorFile, deps *Dependencies, platforms []Platform, repos bool) (*GraphExport, error) {
+	imports, needed := deps.Imports(false)
+	byCanonical := pkgs.ByCanonical()
+	byRepo := pkgs.repoPackages()
+
+	// Map each package to the ID of its node.
+	nodeID := func(pkg *GraphPackage) string {
+		switch {
+		case !repos:
+			return pkg.ImportPath
+		case pkg.Project:
+			return deps.Project
+		case byCanonical[pkg.ImportPath] != nil && byCanonical[pkg.ImportPath].RepositoryRoot != "":
+			return repositoryRootImportPath(byCanonical[pkg.ImportPath].RepositoryRoot)
+		}
+		return pkg.ImportPath
+	}
+
+	export := &GraphExport{Platforms: platforms}
+	nodes := map[string]*GraphExportNode{}
+	edges := map[[2]string]*GraphExportEdge{}
+	patched := map[string]string{}
+	for _, g := range deps.Graphs {
+		for _, pkg := range g.Packages {
+			_, isDep := imports[pkg.ImportPath]
+			if !pkg.Project && !isDep {
+				continue
+			}
+			id := nodeID(pkg)
+			node := nodes[id]
+			if node == nil {
+				node = &GraphExportNode{ID: id, Project: pkg.Project}
+				vpkg := byCanonical[pkg.ImportPath]
+				if repos && vpkg != nil {
+					// Don't depend on which package of the repository is seen first.
+					vpkg = byRepo[vpkg.RepositoryRoot]
+				}
+				if vpkg != nil {
+					node.RepositoryRoot = vpkg.RepositoryRoot
+					node.Revision = vpkg.Revision
+					node.RevisionTime = vpkg.RevisionTime
+					node.Comment = vpkg.Comment
+					node.Source = vpkg.Source
+					if _, found := patched[vpkg.RepositoryRoot]; !found {
+						state, err := patchedState(pkgs, vpkg)
+						if err != nil {
+							return nil, err
+						}
+						patched[vpkg.RepositoryRoot] = state
+					}
+					node.Patched = patched[vpkg.RepositoryRoot]
+				}
+				nodes[id] = node
+				export.Nodes = append(export.Nodes, node)
+			}
+			if dep := needed[pkg.ImportPath]; dep != nil {
+				node.Scope = mergeScope(node.Scope, dep.Scope)
+				node.Platforms = mergePlatforms(platforms, node.Platforms, dep.Platforms)
+			}
+
+			for _, imports := range [][]Import{pkg.Imports, pkg.TestImports} {
+				for _, imp := range imports {
+					to := g.Packages[imp.Path]
+					if _, isDep := needed[imp.Path]; !isDep {
+						continue
+					}
+					key := [2]string{id, nodeID(to)}
+					if key[0] == key[1] {
+						continue
+					}
+					edge := edges[key]
+					if edge == nil {
+						edge = &GraphExportEdge{From: key[0], To: key[1]}
+						edges[key] = edge
+						export.Edges = append(export.Edges, edge)
+					}
+					edge.Platforms = mergePlatforms(platforms, edge.Platforms, []Platform{g.Platform})
+				}
+			}
+		}
+	}
+
+	sort.Sort(graphExportNodesOrder(export.Nodes))
+	sort.Sort(graphExportEdgesOrder(export.Edges))
+	return export, nil
+}
+
+// repoPackages returns, for each repository root, the package representing
+// the whole repository: the one at the root, or else the one with the
+// smallest import path.
+func (v *VendorFile) repoPackages() map[string]*VendorPackage {
+	result := map[string]*VendorPackage{}
+	for _, pkg := range v.Packages {
+		if pkg.RepositoryRoot == "" {
+			continue
+		}
+		prev := result[pkg.RepositoryRoot]
+		atRoot := pkg.Canonical == repositoryRootImportPath(pkg.RepositoryRoot)
+		if prev == nil || atRoot || prev.Canonical != repositoryRootImportPath(prev.RepositoryRoot) && pkg.Canonical < prev.Canonical {
+			result[pkg.RepositoryRoot] = pkg
+		}
+	}
+	return result
+}
+
+// repositoryRootImportPath converts a repositoryRoot (e.g.
+// "_vendor/src/github.com/foo/bar") to an import path ("github.com/foo/bar").
+func repositoryRootImportPath(root string) string {
+	return strings.TrimPrefix(root, path.Join(filepath.ToSlash(VendorPath), "src")+"/")
+}
+
+// patchedState checks if files in repository root of pkg differ from the
+// revision checked out in its .git/.hg/.bzr metadata.
+func patchedState(pkgs *VendorFile, pkg *VendorPackage) (string, error) {
+	vcs, err := vcsList.IsRoot(pkg.RepositoryRoot)
+	if err != nil || vcs == nil {
+		return "unknown", err
+	}
+	clean, err := isCleanInRepo(vcs, pkgs, pkg)
+	switch {
+	case err != nil:
+		return "", err
+	case clean:
+		return "clean", nil
+	}
+	return "patched", nil
+}
+
+// mergePlatforms returns a union of a and b, ordered as in all.
+func mergePlatforms(all, a, b []Platform) []Platform {
+	found := map[Platform]bool{}
+	for _, p := range append(append([]Platform{}, a...), b...) {
+		found[p] = true
+	}
+	result := []Platform{}
+	for _, p := range all {
+		if found[p] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (e *GraphExport) WriteJson(w io.Writer) error {
+	buf, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", buf)
+	return err
+}
+
+func (e *GraphExport) WriteDot(w io.Writer) error {
+	lines := []string{
+		"digraph vendo {",
+		"\tnode [shape=box];",
+	}
+	for _, node := range e.Nodes {
+		label := []string{node.ID}
+		if node.Revision != "" {
+			revision := node.Revision
+			if len(revision) > 12 {
+				revision = revision[:12]
+			}
+			label = append(label, revision+" "+node.RevisionTime)
+		}
+		if node.Patched == "patched" {
+			label = append(label, "PATCHED")
+		}
+		if node.Source != "" {
+			label = append(label, "fork: "+node.Source)
+		}
+		if node.Scope != "" && node.Scope != ScopeBuild {
+			label = append(label, string(node.Scope))
+		}
+		if !node.Project && len(node.Platforms) > 0 && len(node.Platforms) < len(e.Platforms) {
+			label = append(label, formatPlatforms(node.Platforms))
+		}
+		attrs := fmt.Sprintf("label=%s", dotQuote(strings.Join(label, "\n")))
+		switch {
+		case node.Project:
+			attrs += ", style=bold"
+		case node.Patched == "patched":
+			attrs += ", color=red"
+		case node.Scope == ScopeTest || node.Scope == ScopeTransitiveTest:
+			attrs += ", style=dashed"
+		}
+		lines = append(lines, fmt.Sprintf("\t%s [%s];", dotQuote(node.ID), attrs))
+	}
+	for _, edge := range e.Edges {
+		lines = append(lines, fmt.Sprintf("\t%s -> %s;", dotQuote(edge.From), dotQuote(edge.To)))
+	}
+	lines = append(lines, "}")
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+type graphExportNodesOrder []*GraphExportNode
+
+func (p graphExportNodesOrder) Len() int           { return len(p) }
+func (p graphExportNodesOrder) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p graphExportNodesOrder) Less(i, j int) bool { return p[i].ID < p[j].ID }
+
+type graphExportEdgesOrder []*GraphExportEdge
+
+func (p graphExportEdgesOrder) Len() int      { return len(p) }
+func (p graphExportEdgesOrder) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p graphExportEdgesOrder) Less(i, j int) bool {
+	if p[i].From != p[j].From {
+		return p[i].From < p[j].From
+	}
+	return p[i].To < p[j].To
+}
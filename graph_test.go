@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata/")
+
+// testGraphDependencies returns graphs of a project on two platforms, and its
+// vendor.json. Packages of repository github.com/a/a have different comments.
+func testGraphDependencies() (*VendorFile, *Dependencies, []Platform) {
+	linux, windows := Platform{"linux", "amd64"}, Platform{"windows", "amd64"}
+	graph := func(platform Platform, extra ...*GraphPackage) *Graph {
+		g := &Graph{Platform: platform, Packages: map[string]*GraphPackage{
+			"example.com/proj": {ImportPath: "example.com/proj", Project: true,
+				Imports:     []Import{{Path: "github.com/a/a/sub"}, {Path: "fmt"}},
+				TestImports: []Import{{Path: "github.com/t/t"}},
+			},
+			"example.com/proj/cmd": {ImportPath: "example.com/proj/cmd", Project: true,
+				Imports: []Import{{Path: "example.com/proj"}, {Path: "github.com/a/a"}},
+			},
+			"github.com/a/a":     {ImportPath: "github.com/a/a"},
+			"github.com/a/a/sub": {ImportPath: "github.com/a/a/sub", Imports: []Import{{Path: "github.com/a/a"}}},
+			"github.com/t/t":     {ImportPath: "github.com/t/t"},
+			"fmt":                {ImportPath: "fmt", Standard: true},
+		}}
+		for _, pkg := range extra {
+			g.Packages[pkg.ImportPath] = pkg
+			proj := g.Packages["example.com/proj"]
+			proj.Imports = append(proj.Imports, Import{Path: pkg.ImportPath})
+		}
+		return g
+	}
+	deps := &Dependencies{Project: "example.com/proj", Graphs: []*Graph{
+		graph(linux),
+		graph(windows, &GraphPackage{ImportPath: "github.com/w/w"}),
+	}}
+	pkgs := &VendorFile{Packages: []*VendorPackage{
+		{Canonical: "github.com/a/a", RepositoryRoot: "_vendor/src/github.com/a/a", Revision: "0123456789abcdef0123",
+			RevisionTime: "2016-01-02T03:04:05Z", Comment: "patched: root"},
+		{Canonical: "github.com/a/a/sub", RepositoryRoot: "_vendor/src/github.com/a/a", Revision: "0123456789abcdef0123",
+			RevisionTime: "2016-01-02T03:04:05Z", Comment: "patched: sub"},
+		{Canonical: "github.com/t/t", RepositoryRoot: "_vendor/src/github.com/t/t", Revision: "fedcba9876543210fedc",
+			RevisionTime: "2017-01-02T03:04:05Z", Source: "https://github.com/fork/t"},
+		{Canonical: "github.com/w/w", RepositoryRoot: "_vendor/src/github.com/w/w", Revision: "1111111111111111aaaa",
+			RevisionTime: "2018-01-02T03:04:05Z"},
+	}}
+	return pkgs, deps, []Platform{linux, windows}
+}
+
+func Test_GraphExport_Golden(test *testing.T) {
+	cases := []struct {
+		repos  bool
+		golden string
+	}{
+		{false, "packages"},
+		{true, "repos"},
+	}
+	for _, c := range cases {
+		outputs := map[string][]byte{}
+		// NOTE: graphs are maps, so run a few times to catch output depending on iteration order.
+		for i := 0; i < 20; i++ {
+			pkgs, deps, platforms := testGraphDependencies()
+			export, err := newGraphExport(pkgs, deps, platforms, c.repos)
+			if err != nil {
+				test.Fatal(err)
+			}
+			for ext, write := range map[string]func(*bytes.Buffer) error{
+				".dot":  func(buf *bytes.Buffer) error { return export.WriteDot(buf) },
+				".json": func(buf *bytes.Buffer) error { return export.WriteJson(buf) },
+			} {
+				buf := &bytes.Buffer{}
+				err := write(buf)
+				if err != nil {
+					test.Fatal(err)
+				}
+				if prev, found := outputs[ext]; found && !bytes.Equal(prev, buf.Bytes()) {
+					test.Fatalf("%s%s: nondeterministic output:\n%s\nand:\n%s", c.golden, ext, prev, buf.Bytes())
+				}
+				outputs[ext] = buf.Bytes()
+			}
+		}
+		for ext, output := range outputs {
+			path := filepath.Join("testdata", "graph", c.golden+ext)
+			if *updateGolden {
+				err := ioutil.WriteFile(path, output, 0644)
+				if err != nil {
+					test.Fatal(err)
+				}
+				continue
+			}
+			expected, err := ioutil.ReadFile(path)
+			if err != nil {
+				test.Fatal(err)
+			}
+			if !bytes.Equal(output, expected) {
+				test.Errorf("%s: expected:\n%s\ngot:\n%s", path, expected, output)
+			}
+		}
+	}
+}
+
+func Test_VendorFile_repoPackages(test *testing.T) {
+	pkgs := &VendorFile{Packages: []*VendorPackage{
+		{Canonical: "github.com/a/a/z", RepositoryRoot: "_vendor/src/github.com/a/a"},
+		{Canonical: "github.com/a/a", RepositoryRoot: "_vendor/src/github.com/a/a"},
+		{Canonical: "github.com/a/a/b", RepositoryRoot: "_vendor/src/github.com/a/a"},
+		{Canonical: "github.com/b/b/z", RepositoryRoot: "_vendor/src/github.com/b/b"},
+		{Canonical: "github.com/b/b/y", RepositoryRoot: "_vendor/src/github.com/b/b"},
+	}}
+	expected := map[string]string{
+		"_vendor/src/github.com/a/a": "github.com/a/a",
+		"_vendor/src/github.com/b/b": "github.com/b/b/y",
+	}
+	got := pkgs.repoPackages()
+	if len(got) != len(expected) {
+		test.Errorf("expected %d repositories, got %v", len(expected), got)
+	}
+	for root, canonical := range expected {
+		if got[root] == nil || got[root].Canonical != canonical {
+			test.Errorf("%s: expected package %s, got %+v", root, canonical, got[root])
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "identify DIR",
+		Short: "find upstream revision of a vendored repository without .git/.hg/.bzr subdir",
+		Example: fmt.Sprintf("  vendo identify %s/src/rsc.io/pdf\n  vendo identify --repo ~/mirrors/pdf.git --write %s/src/rsc.io/pdf",
+			VendorPath, VendorPath),
+		Long: fmt.Sprintf(
+			`Identify searches a candidate upstream git repository for the commit whose
+tree best matches a snapshot of a repository in %s/ (e.g. copied in by hand,
+without version control metadata and without entry in %s). An exact match
+of the git tree hash is preferred; otherwise, the commit with the smallest
+diff from the snapshot is reported, and the diff can be treated as a local
+patch. To find it, the trees with the fewest differing files are compared line
+by line; only the newest --max-trees distinct trees are searched.
+
+By default, the candidate repository is found in GOPATH, under the import path
+of DIR, or else it's the mirror (see 'vendo mirror') of the origin set with
+--origin or recorded in %s for DIR ("source" or "origin"). DIR must be the
+root directory of the repository.
+
+With --write, entries for all packages in DIR are added to (or updated in)
+%s, so that 'vendo recreate' can use them.`,
+			VendorPath, JsonPath, JsonPath, JsonPath),
+	}
+	var (
+		repo     = cmd.Flags().String("repo", "", "candidate upstream repository (a clone or bare mirror); default: found in GOPATH or mirrors")
+		origin   = cmd.Flags().String("origin", "", "upstream URL of DIR, whose mirror is searched if not found in GOPATH")
+		pruned   = cmd.Flags().Bool("pruned", false, "don't count files missing in DIR as differences (for pruned snapshots)")
+		showDiff = cmd.Flags().Bool("diff", false, "print the full diff between the matching revision and DIR")
+		maxTrees = cmd.Flags().Int("max-trees", 5000, "search only the newest N distinct trees of the repository (0: all)")
+		write    = cmd.Flags().Bool("write", false, "write the matching revision to "+JsonPath)
+	)
+	cmd.Flags().BoolVar(&DryRun, "dry-run", false, dryRunUsage+" (with --write)")
+	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			// TODO(mateuszc): subcmd usage
+			return fmt.Errorf("subcommand 'identify' requires argument specifying directory in %s/", VendorPath)
+		}
+		return Identify(args[0], *repo, *origin, *pruned, *showDiff, *write, *maxTrees)
+	})
+	cmds.AddCommand(cmd)
+}
+
+// Identification is an upstream revision matching a snapshot.
+type Identification struct {
+	Revision     string
+	RevisionTime string
+	Subject      string
+	// Files and Lines count the files and lines (added plus deleted) in the
+	// diff between the revision and the snapshot. Both are zero for an exact
+	// match.
+	Files, Lines int
+	// Candidates is the number of distinct trees compared with the snapshot.
+	Candidates int
+}
+
+func (id *Identification) Exact() bool {
+	return id.Files == 0
+}
+
+func Identify(dir, repo, origin string, pruned, showDiff, write bool, maxTrees int) error {
+	// Make sure we're in project's root dir (with .git/ and _vendor/)
+	exist := Exist{}.GitDir(".git").Dir(VendorPath).Dir(dir)
+	if exist.Err != nil {
+		return exist.Err
+	}
+	dir = filepath.Clean(dir)
+	rel, err := filepath.Rel(filepath.Join(VendorPath, "src"), dir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("directory %s is not in %s", dir, filepath.Join(VendorPath, "src"))
+	}
+	importPath := filepath.ToSlash(rel)
+	if vcs, err := vcsList.IsRoot(dir); err != nil {
+		return err
+	} else if vcs != nil {
+		return fmt.Errorf("%s has %s subdir; its revision is already known", dir, vcs.Dir())
+	}
+
+	if origin == "" {
+		pkgs, err := ReadVendorFile(JsonPath)
+		if err != nil {
+			return err
+		}
+		if pkg := pkgs.ByRepositoryRoot()[filepath.ToSlash(dir)]; pkg != nil {
+			origin = pkg.Source
+			if origin == "" {
+				origin = pkg.Origin
+			}
+		}
+	}
+	if repo == "" {
+		repo, err = findCandidateRepo(importPath, origin)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "# comparing %s with history of %s\n", dir, repo)
+	snapshot, err := newGitSnapshot(repo, dir)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+	id, err := snapshot.Identify(pruned, maxTrees)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("revision: %s\n", id.Revision)
+	fmt.Printf("time:     %s\n", id.RevisionTime)
+	fmt.Printf("subject:  %s\n", id.Subject)
+	if id.Exact() {
+		fmt.Printf("exact match (of %d distinct trees in %s)\n", id.Candidates, repo)
+	} else {
+		fmt.Printf("closest match (of %d distinct trees in %s), with local patch: %d lines in %d files\n",
+			id.Candidates, repo, id.Lines, id.Files)
+		stat, err := snapshot.Diff(id.Revision, pruned, "--stat")
+		if err != nil {
+			return err
+		}
+		fmt.Print(stat)
+	}
+	if showDiff && !id.Exact() {
+		diff, err := snapshot.Diff(id.Revision, pruned)
+		if err != nil {
+			return err
+		}
+		fmt.Print(diff)
+	}
+
+	if !write {
+		fmt.Printf("# to record it in %s, run again with --write\n", JsonPath)
+		return nil
+	}
+	// NOTE: repo may be bare (e.g. a mirror), so its origin is read from the metadata dir.
+	lines, err := Command("git", "--git-dir", snapshot.gitDir, "config", "--get", "remote.origin.url").
+		LogNever().
+		OutputLines()
+	if err == nil && len(lines) > 0 {
+		origin = lines[0]
+	}
+	if origin == "" {
+		origin, err = filepath.Abs(repo)
+		if err != nil {
+			return err
+		}
+	}
+	return writeIdentification(dir, importPath, origin, id, pruned)
+}
+
+// findCandidateRepo returns the repository in GOPATH, whose root has
+// importPath, or else the git mirror of origin, if it exists.
+func findCandidateRepo(importPath, origin string) (string, error) {
+	for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
+		dir := filepath.Join(gopath, "src", filepath.FromSlash(importPath))
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		root, vcs, err := vcsList.FindRoot(dir)
+		switch {
+		case err != nil:
+			return "", err
+		case vcs == nil || len(root) < len(gopath):
+			return "", fmt.Errorf("cannot detect version control system for %s", dir)
+		case root != dir:
+			rootImportPath, err := filepath.Rel(filepath.Join(gopath, "src"), root)
+			if err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("%s is a subdirectory of repository %s; identify %s instead",
+				importPath, root, filepath.Join(VendorPath, "src", rootImportPath))
+		case vcs.Dir() != git{}.Dir():
+			return "", fmt.Errorf("%s repositories are not supported, only git (found %s)", vcsName(vcs), root)
+		}
+		return root, nil
+	}
+	if origin == "" {
+		return "", fmt.Errorf("cannot find %s in GOPATH=%s (set flag --repo or --origin)", importPath, os.Getenv("GOPATH"))
+	}
+	mirror, err := findMirror(git{}, origin)
+	if err != nil {
+		return "", err
+	}
+	if !mirror.Exists() {
+		return "", fmt.Errorf("cannot find %s in GOPATH=%s, nor a mirror of %s (run 'vendo mirror sync', or set flag --repo)",
+			importPath, os.Getenv("GOPATH"), origin)
+	}
+	return mirror.Path, nil
+}
+
+// gitSnapshot is a tree of files from a directory without git metadata,
+// written to a scratch object database of a git repository. The repository's
+// own objects are not modified.
+type gitSnapshot struct {
+	gitDir        string
+	scratch       string
+	removeCleanup func()
+	env           []string
+	Tree          string
+}
+
+func newGitSnapshot(repo, dir string) (*gitSnapshot, error) {
+	gitDir, objects, err := gitObjectDirs(repo)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	scratch, err := ioutil.TempDir("", "vendo-identify-")
+	if err != nil {
+		return nil, err
+	}
+	s := &gitSnapshot{
+		gitDir:        gitDir,
+		scratch:       scratch,
+		removeCleanup: AddCleanup(func() { os.RemoveAll(scratch) }),
+		env: []string{
+			"GIT_INDEX_FILE=" + filepath.Join(scratch, "index"),
+			"GIT_OBJECT_DIRECTORY=" + filepath.Join(scratch, "objects"),
+			"GIT_ALTERNATE_OBJECT_DIRECTORIES=" + objects,
+		},
+	}
+	// NOTE: git requires the object directory to exist.
+	err = os.Mkdir(filepath.Join(scratch, "objects"), 0755)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	// NOTE: the repository's ignore rules (.gitignore files in the snapshot, and .git/info/exclude) are applied, the same as
+	// they were when files were committed upstream.
+	add := s.command("--work-tree", absDir, "add", "-A")
+	add.Cmd.Dir = absDir
+	err = add.DiscardOutput()
+	if err == nil {
+		s.Tree, err = s.command("write-tree").OutputOneLine()
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// gitObjectDirs returns metadata dir and object database of a git repository
+// at root, which may also be bare (e.g. a mirror).
+func gitObjectDirs(root string) (gitDir, objects string, err error) {
+	gitDir, commonDir, err := git{}.metadataDirs(root)
+	if os.IsNotExist(err) {
+		// Bare repository.
+		gitDir, commonDir = root, root
+		exist := Exist{}.File(filepath.Join(root, "HEAD")).Dir(filepath.Join(root, "objects"))
+		if exist.Err != nil {
+			return "", "", fmt.Errorf("not a git repository: %s", root)
+		}
+	} else if err != nil {
+		return "", "", err
+	}
+	objects, err = filepath.Abs(filepath.Join(commonDir, "objects"))
+	if err != nil {
+		return "", "", err
+	}
+	gitDir, err = filepath.Abs(gitDir)
+	return gitDir, objects, err
+}
+
+func (s *gitSnapshot) command(args ...string) *Cmd {
+	return Command("git", "--git-dir", s.gitDir).Append(args...).Setenv(s.env...)
+}
+
+func (s *gitSnapshot) Close() {
+	s.removeCleanup()
+	os.RemoveAll(s.scratch)
+}
+
+// identifyShortlist is the number of trees with the fewest files differing
+// from a snapshot, whose diffs are compared line by line.
+const identifyShortlist = 20
+
+// Identify finds the commit (reachable from any ref) with tree equal to the
+// snapshot, or else with the smallest diff from it. Among equally good
+// commits, the newest one is chosen. If pruned, files missing in the snapshot
+// are not counted as differences. If maxTrees is positive, only the newest
+// maxTrees distinct trees are searched for the smallest diff.
+func (s *gitSnapshot) Identify(pruned bool, maxTrees int) (*Identification, error) {
+	// Output format: "COMMIT TREE" lines, newest first.
+	lines, err := s.command("log", "--all", "--date-order", "--format=%H %T").
+		OutputLines()
+	if err != nil {
+		return nil, err
+	}
+	commitByTree := map[string]string{}
+	trees := []string{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected format of git output: %q", line)
+		}
+		if _, found := commitByTree[fields[1]]; !found {
+			commitByTree[fields[1]] = fields[0]
+			trees = append(trees, fields[1])
+		}
+	}
+	if len(trees) == 0 {
+		return nil, fmt.Errorf("no commits found in %s", s.gitDir)
+	}
+	if maxTrees > 0 && len(trees) > maxTrees {
+		fmt.Fprintf(os.Stderr, "# searching only the newest %d of %d distinct trees (set flag --max-trees)\n", maxTrees, len(trees))
+		trees = trees[:maxTrees]
+	}
+
+	best := &Identification{Candidates: len(trees), Files: -1}
+	if commit, found := commitByTree[s.Tree]; found {
+		best.Revision, best.Files = commit, 0
+	} else {
+		shortlist, err := s.shortlist(trees, pruned, identifyShortlist)
+		if err != nil {
+			return nil, err
+		}
+		for _, tree := range shortlist {
+			files, lines, err := s.diffSize(tree, pruned)
+			if err != nil {
+				return nil, err
+			}
+			if best.Files == -1 || lines < best.Lines || lines == best.Lines && files < best.Files {
+				best.Revision, best.Files, best.Lines = commitByTree[tree], files, lines
+			}
+			if files == 0 {
+				// Possible if pruned.
+				break
+			}
+		}
+	}
+
+	// Output format: revision time and subject, in separate lines.
+	info, err := s.command("log", "-1", "--format=%aD%n%s", best.Revision).
+		OutputLines()
+	if err != nil {
+		return nil, err
+	}
+	if len(info) < 1 {
+		return nil, fmt.Errorf("cannot read info of commit %s", best.Revision)
+	}
+	t, err := time.Parse("Mon, 2 Jan 2006 15:04:05 -0700", info[0])
+	if err != nil {
+		return nil, err
+	}
+	best.RevisionTime = t.Format(time.RFC3339)
+	if len(info) > 1 {
+		best.Subject = info[1]
+	}
+	return best, nil
+}
+
+// shortlist returns up to n of trees, with the fewest files differing from the
+// snapshot; trees with equal number of files keep their order. Only blob
+// hashes are compared, in a single git command, which is much faster than
+// counting the differing lines of each tree.
+func (s *gitSnapshot) shortlist(trees []string, pruned bool, n int) ([]string, error) {
+	if len(trees) <= n {
+		return trees, nil
+	}
+	pairs := []string{}
+	for _, tree := range trees {
+		pairs = append(pairs, tree+" "+s.Tree+"\n")
+	}
+	cmd := s.command("diff-tree", "--stdin", "-r", "--no-renames")
+	if pruned {
+		// Deleted files are missing in the snapshot.
+		cmd.Append("--diff-filter=d")
+	}
+	cmd.Cmd.Stdin = strings.NewReader(strings.Join(pairs, ""))
+	// Output format: "TREE SNAPSHOT" line for each input line, followed by a ":MODES HASHES STATUS\tPATH" line for each
+	// differing file.
+	lines, err := cmd.OutputLines()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]int{}
+	tree := ""
+	for _, line := range lines {
+		if strings.HasPrefix(line, ":") {
+			files[tree]++
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected format of git output: %q", line)
+		}
+		tree = fields[0]
+	}
+	shortlist := append([]string{}, trees...)
+	sort.SliceStable(shortlist, func(i, j int) bool {
+		return files[shortlist[i]] < files[shortlist[j]]
+	})
+	return shortlist[:n], nil
+}
+
+// diffSize returns number of files and lines (added plus deleted) which
+// differ between tree and the snapshot. A binary file counts as one line.
+func (s *gitSnapshot) diffSize(tree string, pruned bool) (files, lines int, err error) {
+	// Output format: "ADDED\tDELETED\tPATH" lines; "-\t-\tPATH" for binary files.
+	out, err := s.diffCommand(tree, pruned, "--numstat").
+		OutputLines()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range out {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return 0, 0, fmt.Errorf("unexpected format of git output: %q", line)
+		}
+		files++
+		if fields[0] == "-" {
+			lines++
+			continue
+		}
+		for _, field := range fields[:2] {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unexpected format of git output: %q", line)
+			}
+			lines += n
+		}
+	}
+	return files, lines, nil
+}
+
+// Diff returns the diff from revision to the snapshot, i.e. the local patch.
+func (s *gitSnapshot) Diff(revision string, pruned bool, args ...string) (string, error) {
+	out, err := s.diffCommand(revision, pruned, args...).
+		CombinedOutput()
+	return string(out), err
+}
+
+func (s *gitSnapshot) diffCommand(from string, pruned bool, args ...string) *Cmd {
+	cmd := s.command("diff", "--no-renames", "--no-ext-diff").Append(args...)
+	if pruned {
+		// Deleted files are missing in the snapshot.
+		cmd.Append("--diff-filter=d")
+	}
+	return cmd.Append(from, s.Tree, "--")
+}
+
+// writeIdentification adds to vendor.json entries for all packages in dir,
+// with revision identified by id.
+func writeIdentification(dir, importPath, origin string, id *Identification, pruned bool) error {
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		return err
+	}
+	if pkgs == nil {
+		pkgs = &VendorFile{Tool: "github.com/zpas-lab/vendo"}
+	}
+	byCanonical := pkgs.ByCanonical()
+	comment := ""
+	if !id.Exact() {
+		comment = fmt.Sprintf("locally patched: %d lines in %d files differ from upstream (see `vendo identify --diff %s`)",
+			id.Lines, id.Files, filepath.ToSlash(dir))
+	}
+
+	imports := []string{}
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if rel != "." && ignoredByGo(filepath.ToSlash(rel)) {
+			return filepath.SkipDir
+		}
+		goFiles, err := filepath.Glob(filepath.Join(file, "*.go"))
+		if err != nil || len(goFiles) == 0 {
+			return err
+		}
+		imports = append(imports, path.Join(importPath, filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(imports)
+
+	for _, imp := range imports {
+		pkg := byCanonical[imp]
+		if pkg == nil {
+			pkg = &VendorPackage{Canonical: imp}
+			pkgs.Packages = append(pkgs.Packages, pkg)
+		}
+		pkg.Local = filepath.ToSlash(filepath.Join(VendorPath, "src", imp))
+		pkg.RepositoryRoot = filepath.ToSlash(dir)
+		pkg.Revision = id.Revision
+		pkg.RevisionTime = id.RevisionTime
+		pkg.Vcs = vcsName(git{})
+		if pkg.Source != origin {
+			// The recorded upstream is kept when the revision was found in the fork.
+			pkg.Origin = origin
+		}
+		pkg.Pruned = pruned
+		if comment != "" && pkg.Comment == "" {
+			pkg.Comment = comment
+		}
+		fmt.Printf("# %s: %s\n", imp, id.Revision)
+	}
+	sort.Sort(PackagesOrder(pkgs.Packages))
+	return pkgs.WriteTo(JsonPath)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_gitSnapshot_Identify(test *testing.T) {
+	requireVcs(test, "git")
+	tmp, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	env := []string{"GIT_CONFIG_NOSYSTEM=1", "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com"}
+	run := func(dir string, args ...string) string {
+		cmd := Command(args[0], args[1:]...).Setenv(env...)
+		cmd.Cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			test.Fatalf("%s: %s", strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(path, data string) {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(data), 0644)
+		}
+		if err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// Upstream repository with 3 commits, changing a.go in each.
+	repo := filepath.Join(tmp, "repo")
+	run(tmp, "git", "init", "-q", repo)
+	revisions := []string{}
+	for i, content := range []string{"1\n2\n3\n", "1\n2\n3\n4\n", "1\n2\n3\n4\n5\n6\n7\n"} {
+		write(filepath.Join(repo, "a.go"), content)
+		if i == 0 {
+			write(filepath.Join(repo, "sub", "b.go"), "b\n")
+		}
+		run(repo, "git", "add", "-A")
+		run(repo, "git", "commit", "-q", "-m", "commit")
+		revisions = append(revisions, run(repo, "git", "rev-parse", "HEAD"))
+	}
+
+	cases := []struct {
+		note     string
+		files    map[string]string
+		pruned   bool
+		expected string
+		exact    bool
+		lines    int
+	}{
+		{"exact", map[string]string{"a.go": "1\n2\n3\n4\n", "sub/b.go": "b\n"}, false, revisions[1], true, 0},
+		{"patched", map[string]string{"a.go": "1\n2\n3\n4\n5\n", "sub/b.go": "b\n"}, false, revisions[1], false, 1},
+		{"pruned", map[string]string{"a.go": "1\n2\n3\n"}, true, revisions[0], true, 0},
+		{"missing file", map[string]string{"a.go": "1\n2\n3\n"}, false, revisions[0], false, 1},
+	}
+	for _, c := range cases {
+		dir := filepath.Join(tmp, "snapshot", strings.Replace(c.note, " ", "-", -1))
+		for name, content := range c.files {
+			write(filepath.Join(dir, filepath.FromSlash(name)), content)
+		}
+		snapshot, err := newGitSnapshot(repo, dir)
+		if err != nil {
+			test.Fatal(err)
+		}
+		id, err := snapshot.Identify(c.pruned, 0)
+		snapshot.Close()
+		if err != nil {
+			test.Fatal(err)
+		}
+		if id.Revision != c.expected || id.Exact() != c.exact || id.Lines != c.lines || id.Candidates != 3 {
+			test.Errorf("%s: expected revision %s, exact=%v, lines=%d; got %+v", c.note, c.expected, c.exact, c.lines, id)
+		}
+	}
+
+	// Trees with the fewest differing files are shortlisted, newest first among equal ones; the search can be limited to
+	// the newest trees.
+	trees := []string{}
+	for i := len(revisions) - 1; i >= 0; i-- {
+		trees = append(trees, run(repo, "git", "rev-parse", revisions[i]+"^{tree}"))
+	}
+	snapshot, err := newGitSnapshot(repo, filepath.Join(tmp, "snapshot", "missing-file"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer snapshot.Close()
+	shortlist, err := snapshot.shortlist(trees, false, 2)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if expected := []string{trees[2], trees[0]}; !reflect.DeepEqual(shortlist, expected) {
+		test.Errorf("shortlist: expected %q, got %q", expected, shortlist)
+	}
+	id, err := snapshot.Identify(false, 2)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if id.Revision != revisions[1] || id.Candidates != 2 {
+		test.Errorf("max 2 trees: expected revision %s of 2 candidates, got %+v", revisions[1], id)
+	}
+
+	// The upstream repository is not modified.
+	if status := run(repo, "git", "status", "--porcelain"); status != "" {
+		test.Errorf("expected clean upstream repository, got:\n%s", status)
+	}
+
+	// Without a clone in GOPATH, the mirror of the origin is the candidate.
+	defer func(gopath, mirrorDir string) {
+		os.Setenv("GOPATH", gopath)
+		MirrorDir = mirrorDir
+	}(os.Getenv("GOPATH"), MirrorDir)
+	os.Setenv("GOPATH", filepath.Join(tmp, "gopath"))
+	MirrorDir = filepath.Join(tmp, "mirrors")
+	origin := "https://example.com/repo"
+	_, err = findCandidateRepo("example.com/repo", origin)
+	if err == nil {
+		test.Errorf("expected error without a clone in GOPATH nor a mirror")
+	}
+	mirror, err := findMirror(git{}, origin)
+	if err == nil {
+		err = mirror.Create(repo)
+	}
+	if err != nil {
+		test.Fatal(err)
+	}
+	candidate, err := findCandidateRepo("example.com/repo", origin)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if candidate != mirror.Path {
+		test.Errorf("expected mirror %s as the candidate, got %s", mirror.Path, candidate)
+	}
+	cleanups.Lock()
+	numCleanups := len(cleanups.funcs)
+	cleanups.Unlock()
+	snapshot, err = newGitSnapshot(candidate, filepath.Join(tmp, "snapshot", "exact"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	id, err = snapshot.Identify(false, 0)
+	snapshot.Close()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if id.Revision != revisions[1] || !id.Exact() {
+		test.Errorf("mirror: expected exact revision %s, got %+v", revisions[1], id)
+	}
+
+	// The scratch directory is removed on Close, or by a cleanup if vendo is interrupted.
+	if _, err := os.Stat(snapshot.scratch); !os.IsNotExist(err) {
+		test.Errorf("expected %s removed, got: %v", snapshot.scratch, err)
+	}
+	cleanups.Lock()
+	if len(cleanups.funcs) != numCleanups {
+		test.Errorf("expected cleanup of %s unregistered on Close", snapshot.scratch)
+	}
+	cleanups.Unlock()
+}
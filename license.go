@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "licenses",
+		Short: "print a report of licenses of vendored repositories",
+		Long: fmt.Sprintf(
+			`Licenses prints the license of each repository vendored in %s/,
+as detected and recorded in %s by 'recreate'. If %s contains an
+"allowedLicenses" list (the project's license policy), repositories with
+licenses not on the list are marked as DISALLOWED.`,
+			VendorPath, JsonPath, JsonPath),
+	}
+	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		return Licenses()
+	})
+	cmds.AddCommand(cmd)
+}
+
+const UnknownLicense = "unknown"
+
+// licenseMatchers is an offline matcher of license texts to SPDX identifiers.
+// A text matches a license if it contains all of the phrases, after
+// normalization (see normalizeLicenseText). The first phrase is the license's
+// title, if it has one. If multiple entries match, the one whose title is
+// found earliest in the text wins (see ClassifyLicense); on ties, the first
+// entry wins, so the more specific licenses must come first.
+var licenseMatchers = []struct {
+	id      string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2 1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "version 2 0"}},
+	{"Apache-2.0", []string{"apache license", "version 2 0"}},
+	{"EPL-1.0", []string{"eclipse public license", "v 1 0"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1 0 universal"}},
+	{"WTFPL", []string{"do what the fuck you want to public license"}},
+	{"ISC", []string{"permission to use copy modify and or distribute this software for any purpose with or without fee is hereby granted"}},
+	{"MIT", []string{"permission is hereby granted free of charge to any person obtaining a copy"}},
+	{"BSD-4-Clause", []string{"redistribution and use in source and binary forms", "advertising materials"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms", "this list of conditions"}},
+	{"Zlib", []string{"altered source versions must be plainly marked as such"}},
+}
+
+// normalizeLicenseText lowercases text and replaces any sequence of
+// non-alphanumeric characters with a single space.
+func normalizeLicenseText(text string) string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	})
+	return " " + strings.Join(fields, " ") + " "
+}
+
+// ClassifyLicense returns SPDX identifier of the license in text, or
+// UnknownLicense.
+//
+// NOTE: full license texts often name other licenses (e.g. GPL-3.0 has a
+// section about the GNU Affero General Public License, and MPL-2.0 lists GPL
+// family as "Secondary Licenses"), but they start with their own title.
+func ClassifyLicense(text string) string {
+	text = normalizeLicenseText(text)
+	best, bestPos := UnknownLicense, len(text)
+nextMatcher:
+	for _, m := range licenseMatchers {
+		pos := -1
+		for i, phrase := range m.phrases {
+			p := strings.Index(text, " "+phrase+" ")
+			if p == -1 {
+				continue nextMatcher
+			}
+			if i == 0 {
+				pos = p
+			}
+		}
+		if pos < bestPos {
+			best, bestPos = m.id, pos
+		}
+	}
+	return best
+}
+
+// isLicenseFile returns true for names of files typically containing license
+// or attribution texts, like: LICENSE, LICENSE.md, LICENCE-MIT, COPYING, NOTICE.
+func isLicenseFile(name string) bool {
+	name = strings.ToUpper(name)
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "UNLICENSE", "NOTICE"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNoticeFile(name string) bool {
+	return strings.HasPrefix(strings.ToUpper(name), "NOTICE")
+}
+
+// DetectLicense classifies contents of license files found in a repository
+// root (see isLicenseFile), keyed by file name. It returns an SPDX license
+// expression (like "MIT", or "Apache-2.0 AND MIT" if multiple licenses were
+// found), and sorted names of all the license and notice files. NOTICE files
+// are not classified. If no license could be classified, UnknownLicense is
+// returned.
+func DetectLicense(files map[string][]byte) (string, []string) {
+	names := []string{}
+	ids := set{}
+	for name, data := range files {
+		names = append(names, name)
+		if isNoticeFile(name) {
+			continue
+		}
+		id := ClassifyLicense(string(data))
+		if id != UnknownLicense {
+			ids.Add(id)
+		}
+	}
+	sort.Strings(names)
+	if len(ids) == 0 {
+		return UnknownLicense, names
+	}
+	licenses := ids.ToSlice()
+	sort.Strings(licenses)
+	return strings.Join(licenses, " AND "), names
+}
+
+// readLicenseFiles reads license and notice files from repository root on disk.
+func readLicenseFiles(root string) (map[string][]byte, error) {
+	infos, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	for _, info := range infos {
+		if info.IsDir() || !isLicenseFile(info.Name()) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(root, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[info.Name()] = data
+	}
+	return files, nil
+}
+
+// readStagedLicenseFiles reads license and notice files of repository root
+// from git's "staging area" (index).
+func readStagedLicenseFiles(root string) (map[string][]byte, error) {
+	names := []string{}
+	err := git{}.WalkStaged(".", root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir() && path != root:
+			return filepath.SkipDir
+		case !info.IsDir() && isLicenseFile(info.Name()):
+			names = append(names, info.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	for _, name := range names {
+		data, err := readStaged(path.Join(root, name))
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// detectLicenses fills "license" and "licenseFiles" of packages, based on
+// files found on disk in their repository roots.
+func detectLicenses(packages []*VendorPackage) error {
+	defer Phase("licenses")()
+	type result struct {
+		license string
+		files   []string
+	}
+	detected := map[string]result{}
+	for _, pkg := range packages {
+		r, found := detected[pkg.RepositoryRoot]
+		if !found {
+			files, err := readLicenseFiles(pkg.RepositoryRoot)
+			if err != nil && !(DryRun && os.IsNotExist(err)) {
+				// NOTE: in DryRun mode, repositories are not cloned to _vendor/, so their license is unknown.
+				return err
+			}
+			r.license, r.files = DetectLicense(files)
+			detected[pkg.RepositoryRoot] = r
+		}
+		pkg.License, pkg.LicenseFiles = r.license, r.files
+	}
+	return nil
+}
+
+// IsLicenseAllowed checks if all licenses in SPDX license expression (as
+// returned by DetectLicense) are on the allowed list.
+func IsLicenseAllowed(license string, allowed []string) bool {
+	for _, id := range strings.Split(license, " AND ") {
+		ok := false
+		for _, a := range allowed {
+			if id == a {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckLicenses verifies licenses of repositories added or updated (i.e. with
+// changed "revision") in vendor.json since HEAD. Their licenses are detected
+// from files in git's "staging area", must match the "license" recorded in
+// vendor.json, and must be listed in "allowedLicenses" in vendor.json. If the
+// project has no "allowedLicenses", the check is skipped.
+func CheckLicenses() (Findings, error) {
+
+	// NOTE: this function operates strictly on files in git's "staging area" (index).
+	// ANY MODIFICATIONS MUST KEEP THIS INVARIANT.
+
+	// Make sure we're in project's root dir (with .git/, vendor.json, and _vendor/)
+	exist := Exist{}.GitDir(".git").File(JsonPath).Dir(VendorPath)
+	if exist.Err != nil {
+		return nil, exist.Err
+	}
+
+	pkgs, err := ReadStagedVendorFile(JsonPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs.AllowedLicenses) == 0 {
+		return nil, nil
+	}
+	oldPkgs, err := ReadHeadVendorFile(JsonPath)
+	if err != nil {
+		return nil, err
+	}
+	oldByRepoRoot := oldPkgs.ByRepositoryRoot()
+
+	findings := Findings{}
+	checked := map[string]bool{}
+	for _, pkg := range pkgs.Packages {
+		old := oldByRepoRoot[pkg.RepositoryRoot]
+		if checked[pkg.RepositoryRoot] || (old != nil && old.Revision == pkg.Revision) {
+			continue
+		}
+		checked[pkg.RepositoryRoot] = true
+
+		files, err := readStagedLicenseFiles(pkg.RepositoryRoot)
+		if err != nil {
+			return nil, err
+		}
+		license, _ := DetectLicense(files)
+		switch {
+		case license != pkg.License:
+			f := findings.Add(CheckIdLicenses, "license detected as %q, but %q in %s", license, pkg.License, JsonPath)
+			f.RepositoryRoot = pkg.RepositoryRoot
+			f.Fix = "run `vendo recreate`"
+		case !IsLicenseAllowed(license, pkgs.AllowedLicenses):
+			f := findings.Add(CheckIdLicenses, "license %q is not allowed (allowed licenses: %s)",
+				license, strings.Join(pkgs.AllowedLicenses, ", "))
+			f.RepositoryRoot = pkg.RepositoryRoot
+		}
+	}
+	return findings, nil
+}
+
+func Licenses() error {
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		return err
+	}
+	if pkgs.Packages == nil {
+		return fmt.Errorf("file not found or empty: %s", JsonPath)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	counts := map[string]int{}
+	reported := map[string]bool{}
+	for _, pkg := range pkgs.Packages {
+		if reported[pkg.RepositoryRoot] {
+			continue
+		}
+		reported[pkg.RepositoryRoot] = true
+		license := pkg.License
+		if license == "" {
+			license = "?"
+		}
+		counts[license]++
+		policy := ""
+		if len(pkgs.AllowedLicenses) > 0 && !IsLicenseAllowed(pkg.License, pkgs.AllowedLicenses) {
+			policy = "DISALLOWED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			repositoryRootImportPath(pkg.RepositoryRoot), license, strings.Join(pkg.LicenseFiles, " "), policy)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	licenses := []string{}
+	for license := range counts {
+		licenses = append(licenses, license)
+	}
+	sort.Strings(licenses)
+	fmt.Println()
+	for _, license := range licenses {
+		fmt.Printf("%d\t%s\n", counts[license], license)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_ClassifyLicense(test *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{`The MIT License (MIT)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software")...`, "MIT"},
+		{`Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+   * Redistributions of source code must retain the above copyright
+notice, this list of conditions and the following disclaimer.
+   * Neither the name of Google Inc. nor the names of its
+contributors may be used to endorse or promote products...`, "BSD-3-Clause"},
+		{`Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.`, "BSD-2-Clause"},
+		{`
+                                 Apache License
+                           Version 2.0, January 2004
+                        http://www.apache.org/licenses/`, "Apache-2.0"},
+		{`GNU LESSER GENERAL PUBLIC LICENSE
+                       Version 2.1, February 1999`, "LGPL-2.1"},
+		{"All rights reserved.", UnknownLicense},
+	}
+	for _, c := range cases {
+		result := ClassifyLicense(c.text)
+		if result != c.expected {
+			test.Errorf("expected %q, got %q for:\n%s", c.expected, result, c.text)
+		}
+	}
+}
+
+// Test_ClassifyLicense_FullTexts classifies full license texts from
+// testdata/licenses/, named by their SPDX identifiers. Some of them mention
+// other licenses, e.g. GPL-3.0 mentions AGPL-3.0.
+func Test_ClassifyLicense_FullTexts(test *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "licenses", "*"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) == 0 {
+		test.Fatal("no license texts found in testdata/licenses")
+	}
+	for _, file := range files {
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			test.Fatal(err)
+		}
+		expected := filepath.Base(file)
+		if result := ClassifyLicense(string(text)); result != expected {
+			test.Errorf("expected %q, got %q for %s", expected, result, file)
+		}
+	}
+}
+
+func Test_DetectLicense(test *testing.T) {
+	license, files := DetectLicense(map[string][]byte{
+		"NOTICE":      []byte("This product includes software under the Apache License, Version 2.0"),
+		"LICENSE-MIT": []byte("Permission is hereby granted, free of charge, to any person obtaining a copy"),
+		"LICENSE":     []byte("Apache License\nVersion 2.0, January 2004"),
+	})
+	if license != "Apache-2.0 AND MIT" {
+		test.Errorf("expected license %q, got %q", "Apache-2.0 AND MIT", license)
+	}
+	expectedFiles := []string{"LICENSE", "LICENSE-MIT", "NOTICE"}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		test.Errorf("expected files %q, got %q", expectedFiles, files)
+	}
+
+	license, files = DetectLicense(map[string][]byte{})
+	if license != UnknownLicense || len(files) != 0 {
+		test.Errorf("expected %q and no files, got %q and %q", UnknownLicense, license, files)
+	}
+
+	allowed := []string{"MIT", "Apache-2.0"}
+	for license, expected := range map[string]bool{
+		"MIT":                true,
+		"Apache-2.0 AND MIT": true,
+		"GPL-2.0 AND MIT":    false,
+		UnknownLicense:       false,
+	} {
+		if IsLicenseAllowed(license, allowed) != expected {
+			test.Errorf("expected IsLicenseAllowed(%q) == %v", license, expected)
+		}
+	}
+}
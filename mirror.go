@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// MirrorDir is the directory with local mirrors of upstream repositories
+// (flag --mirror-dir). If empty, $VENDO_MIRROR_DIR is used, or "vendo/mirrors"
+// in user's cache directory.
+var MirrorDir = ""
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "mirror",
+		Short: "manage local mirrors of upstream repositories",
+		Long: fmt.Sprintf(
+			`Vendo keeps bare mirrors of upstream git and hg repositories in a cache
+directory (flag --mirror-dir, or $VENDO_MIRROR_DIR, or "vendo/mirrors" in
+user's cache directory), keyed by origin URL recorded in %s.
+
+Commands which need upstream history (e.g. 'update') fetch it from the mirror,
+if present, after trying to refresh it from the origin. This makes it possible
+to work offline, e.g. on CI or in an air-gapped network, after 'mirror sync'.`,
+			JsonPath),
+	}
+	sync := &cobra.Command{
+		Use:   "sync",
+		Short: "create and refresh mirrors of all repositories in " + JsonPath,
+		Long: fmt.Sprintf(
+			`Sync creates a mirror for each repository listed in %s with a known origin,
+if not present yet: from a clone in GOPATH or in %s/ if available, or
+else from the origin. Then, all the mirrors are refreshed from their origins.`,
+			JsonPath, VendorPath),
+	}
+	offline := sync.Flags().Bool("offline", false, "don't contact origins; only create missing mirrors from local clones")
+	sync.Flags().BoolVar(&DryRun, "dry-run", false, dryRunUsage)
+	sync.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		return SyncMirrors(*offline)
+	})
+	path := &cobra.Command{
+		Use:     "path ORIGIN",
+		Short:   "print the directory of the mirror of a repository",
+		Example: "  vendo identify --repo $(vendo mirror path https://github.com/rsc/pdf) _vendor/src/rsc.io/pdf",
+	}
+	vcs := path.Flags().String("vcs", "git", "version control system of the repository: git or hg")
+	path.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			// TODO(mateuszc): subcmd usage
+			return fmt.Errorf("subcommand 'mirror path' requires argument specifying origin URL")
+		}
+		mirror, err := findMirror(vcsList.ByName(*vcs), args[0])
+		if err != nil {
+			return err
+		}
+		if mirror == nil {
+			return fmt.Errorf("mirrors of %q repositories are not supported", *vcs)
+		}
+		fmt.Println(mirror.Path)
+		return nil
+	})
+	cmd.AddCommand(sync, path)
+	cmds.AddCommand(cmd)
+}
+
+// Mirror is a bare clone of an upstream repository, in MirrorDir.
+type Mirror struct {
+	Vcs    Vcs
+	Origin string
+	Path   string
+}
+
+// findMirror returns the Mirror of origin, which may not exist yet, or nil if
+// mirrors are not supported for vcs (only git and hg are).
+func findMirror(vcs Vcs, origin string) (*Mirror, error) {
+	switch vcs.(type) {
+	case git, mercurial:
+	default:
+		return nil, nil
+	}
+	dir := MirrorDir
+	if dir == "" {
+		dir = os.Getenv("VENDO_MIRROR_DIR")
+	}
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("cannot find directory for mirrors (set flag --mirror-dir): %s", err)
+		}
+		dir = filepath.Join(cache, "vendo", "mirrors")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Mirror{
+		Vcs:    vcs,
+		Origin: origin,
+		Path:   filepath.Join(dir, vcsName(vcs), mirrorName(origin)),
+	}, nil
+}
+
+// mirrorName returns a directory name for the mirror of origin: readable, but
+// unique thanks to a hash of the full origin.
+func mirrorName(origin string) string {
+	hash := sha1.Sum([]byte(origin))
+	name := origin
+	if i := strings.Index(name, "://"); i != -1 {
+		name = name[i+3:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, strings.Trim(name, "/"))
+	if len(name) > 64 {
+		name = name[len(name)-64:]
+	}
+	return name + "-" + hex.EncodeToString(hash[:4])
+}
+
+func (m *Mirror) Exists() bool {
+	_, err := os.Stat(m.Path)
+	return err == nil
+}
+
+// Create clones the mirror from a local clone of the repository, or from the
+// origin if from is empty.
+func (m *Mirror) Create(from string) error {
+	if from == "" {
+		from = m.Origin
+	}
+	if DryRun {
+		planned("create mirror of %s from %s, in: %s", m.Origin, from, m.Path)
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(m.Path), 0755)
+	if err != nil {
+		return err
+	}
+	// Clone to a temporary directory first, so that an interrupted clone doesn't leave a broken mirror.
+	tmp := m.Path + ".tmp"
+	err = os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+	remove := AddCleanup(func() { os.RemoveAll(tmp) })
+	defer remove()
+	fmt.Fprintf(os.Stderr, "# creating mirror of %s from %s\n", m.Origin, from)
+	switch m.Vcs.(type) {
+	case git:
+		err = Command("git", "clone", "--quiet", "--mirror", "--", from, tmp).LogAlways().DiscardOutput()
+		if err == nil {
+			err = Command("git", "--git-dir", tmp, "config", "remote.origin.url", m.Origin).DiscardOutput()
+		}
+	case mercurial:
+		err = Command("hg", "clone", "--quiet", "--noupdate", "--", from, tmp).LogAlways().DiscardOutput()
+		if err == nil {
+			err = m.Vcs.SetOrigin(tmp, m.Origin)
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, m.Path)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// Sync downloads new revisions from the origin to the mirror.
+func (m *Mirror) Sync() error {
+	if DryRun {
+		planned("refresh mirror of %s, in: %s", m.Origin, m.Path)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "# refreshing mirror of %s\n", m.Origin)
+	switch m.Vcs.(type) {
+	case git:
+		err := Command("git", "--git-dir", m.Path, "fetch", "--quiet", "--prune", "origin").LogAlways().DiscardOutput()
+		if err != nil {
+			return err
+		}
+		// Point HEAD at origin's default branch, which is checked out by 'update' by default. (It may be detached if the
+		// mirror was created from a local clone.)
+		// Output format: "ref: refs/heads/master\tHEAD" line, followed by "HASH\tHEAD" line.
+		line, err := firstLine(Command("git", "--git-dir", m.Path, "ls-remote", "--symref", "origin", "HEAD"))
+		if err != nil || !strings.HasPrefix(line, "ref: ") {
+			return err
+		}
+		ref := strings.Fields(strings.TrimPrefix(line, "ref: "))[0]
+		return Command("git", "--git-dir", m.Path, "symbolic-ref", "HEAD", ref).DiscardOutput()
+	case mercurial:
+		return Command("hg", "-R", m.Path, "pull", "--quiet").LogAlways().DiscardOutput()
+	}
+	return nil
+}
+
+// SyncMirrors creates and refreshes mirrors of all repositories in
+// vendor.json. Failures are reported for each repository, without stopping.
+func SyncMirrors(offline bool) error {
+	pkgs, err := ReadVendorFile(JsonPath)
+	switch {
+	case err != nil:
+		return err
+	case pkgs == nil:
+		return fmt.Errorf("file not found: %s", JsonPath)
+	}
+	byRoot := pkgs.ByRepositoryRoot()
+	roots := []string{}
+	for root := range byRoot {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	failed := []string{}
+	for _, root := range roots {
+		pkg := byRoot[root]
+		vcs := vcsList.ByName(pkg.Vcs)
+		if vcs == nil || pkg.Origin == "" {
+			fmt.Fprintf(os.Stderr, "# skipping %s: origin not known (run 'vendo recreate' or 'vendo update' to record it)\n", root)
+			continue
+		}
+		mirror, err := findMirror(vcs, pkg.Origin)
+		if err != nil {
+			return err
+		}
+		if mirror == nil {
+			fmt.Fprintf(os.Stderr, "# skipping %s: mirrors of %s repositories are not supported\n", root, pkg.Vcs)
+			continue
+		}
+		err = mirror.syncFromLocal(pkg, offline)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", root, err)
+			failed = append(failed, root)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("cannot sync mirrors of: %s", strings.Join(failed, " "))
+	}
+	return nil
+}
+
+// syncFromLocal creates the mirror, if not present yet, from a clone of the
+// repository of pkg in GOPATH or _vendor/, or else from the origin (unless
+// offline); then it refreshes the mirror from the origin (unless offline).
+func (m *Mirror) syncFromLocal(pkg *VendorPackage, offline bool) error {
+	if !m.Exists() {
+		from, err := findLocalClone(pkg, m.Vcs)
+		if err != nil {
+			return err
+		}
+		if from == "" && offline {
+			return fmt.Errorf("no local clone found for mirror of %s", m.Origin)
+		}
+		err = m.Create(from)
+		if err != nil {
+			return err
+		}
+	}
+	if offline {
+		return nil
+	}
+	return m.Sync()
+}
+
+// findLocalClone returns root of a repository of pkg in GOPATH, or in
+// _vendor/ if it has .git/.hg subdir, or empty string if not found.
+func findLocalClone(pkg *VendorPackage, vcs Vcs) (string, error) {
+	candidates := []string{pkg.RepositoryRoot}
+	vendorSrc := filepath.ToSlash(filepath.Join(VendorPath, "src")) + "/"
+	if strings.HasPrefix(pkg.RepositoryRoot, vendorSrc) {
+		repoImportPath := strings.TrimPrefix(pkg.RepositoryRoot, vendorSrc)
+		for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
+			candidates = append(candidates, filepath.Join(gopath, "src", filepath.FromSlash(repoImportPath)))
+		}
+	}
+	for _, dir := range candidates {
+		found, err := vcsList.IsRoot(dir)
+		if err != nil {
+			return "", err
+		}
+		if found != nil && vcsName(found) == vcsName(vcs) {
+			return filepath.Abs(dir)
+		}
+	}
+	return "", nil
+}
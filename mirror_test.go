@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_mirrorName(test *testing.T) {
+	cases := []struct {
+		origin, expectedPrefix string
+	}{
+		{"https://github.com/rsc/pdf", "github.com_rsc_pdf-"},
+		{"https://github.com/rsc/pdf/", "github.com_rsc_pdf-"},
+		{"git@github.com:rsc/pdf.git", "git_github.com_rsc_pdf.git-"},
+		{"/home/user/go/src/rsc.io/pdf", "home_user_go_src_rsc.io_pdf-"},
+	}
+	seen := map[string]bool{}
+	for _, c := range cases {
+		name := mirrorName(c.origin)
+		if !strings.HasPrefix(name, c.expectedPrefix) || len(name) != len(c.expectedPrefix)+8 {
+			test.Errorf("mirrorName(%q): expected %q + 8 hex digits, got %q", c.origin, c.expectedPrefix, name)
+		}
+		if seen[name] {
+			test.Errorf("mirrorName(%q): duplicate name %q", c.origin, name)
+		}
+		seen[name] = true
+	}
+}
+
+func Test_Mirror_git(test *testing.T) {
+	requireVcs(test, "git")
+	tmp, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	env := []string{"GIT_CONFIG_NOSYSTEM=1", "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com"}
+	run := func(dir string, args ...string) string {
+		cmd := Command(args[0], args[1:]...).Setenv(env...)
+		cmd.Cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			test.Fatalf("%s: %s", strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(repo, content string) string {
+		err := ioutil.WriteFile(filepath.Join(repo, "a.go"), []byte(content), 0644)
+		if err != nil {
+			test.Fatal(err)
+		}
+		run(repo, "git", "add", "-A")
+		run(repo, "git", "commit", "-q", "-m", content)
+		return run(repo, "git", "rev-parse", "HEAD")
+	}
+
+	origin := filepath.Join(tmp, "origin")
+	run(tmp, "git", "-c", "init.defaultBranch=main", "init", "-q", origin)
+	commit(origin, "1")
+	// A local clone with detached HEAD, as in _vendor/.
+	local := filepath.Join(tmp, "local")
+	run(tmp, "git", "clone", "-q", origin, local)
+	run(local, "git", "checkout", "-q", "--detach")
+
+	defer func(dir string) { MirrorDir = dir }(MirrorDir)
+	MirrorDir = filepath.Join(tmp, "mirrors")
+	mirror, err := findMirror(git{}, origin)
+	if err != nil || mirror == nil {
+		test.Fatalf("expected mirror, got %v, %v", mirror, err)
+	}
+	if mirror.Exists() {
+		test.Fatal("expected no mirror before Create")
+	}
+	err = mirror.Create(local)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !mirror.Exists() {
+		test.Fatal("expected mirror after Create")
+	}
+	if url := run(tmp, "git", "--git-dir", mirror.Path, "config", "remote.origin.url"); url != origin {
+		test.Errorf("expected mirror's origin %q, got %q", origin, url)
+	}
+
+	rev2 := commit(origin, "2")
+	err = mirror.Sync()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if head := run(tmp, "git", "--git-dir", mirror.Path, "rev-parse", "HEAD"); head != rev2 {
+		test.Errorf("expected mirror's HEAD at %s after Sync, got %s", rev2, head)
+	}
+	if ref := run(tmp, "git", "--git-dir", mirror.Path, "symbolic-ref", "HEAD"); ref != "refs/heads/main" {
+		test.Errorf("expected mirror's HEAD to be refs/heads/main, got %s", ref)
+	}
+
+	// Fetching from the mirror gives the origin's default head.
+	fetched, err := git{}.Fetch(local, mirror.Path, "")
+	if err != nil || fetched != rev2 {
+		test.Errorf("expected Fetch from mirror to return %s, got %q, %v", rev2, fetched, err)
+	}
+}
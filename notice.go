@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const NoticePath = "THIRD_PARTY_NOTICES"
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "notice",
+		Short: "generate attribution file for vendored repositories",
+		Long: fmt.Sprintf(
+			`Notice writes a file with license and notice texts of all repositories
+vendored in %s/, together with their canonical import paths, revisions and
+revision times, as recorded in %s. License files are the ones detected by
+'recreate' (see 'vendo licenses'). The output is deterministic, so it can be
+committed and verified with '--check'.
+
+By default, the file is written to %s (or %s.md for
+'--format=markdown').`,
+			VendorPath, JsonPath, NoticePath, NoticePath),
+	}
+	var (
+		format = cmd.Flags().String("format", "text", "output format: text or markdown")
+		output = cmd.Flags().String("output", "", "path of the generated file")
+		check  = cmd.Flags().Bool("check", false, "don't write anything; fail if the file in git's staging area is out of date with "+JsonPath)
+	)
+	cmd.Flags().BoolVar(&DryRun, "dry-run", false, dryRunUsage)
+	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		if *format != "text" && *format != "markdown" {
+			// TODO(mateuszc): subcmd usage
+			return fmt.Errorf("unknown format %q, expected: text or markdown", *format)
+		}
+		if *output == "" {
+			*output = NoticePath
+			if *format == "markdown" {
+				*output += ".md"
+			}
+		}
+		if *check {
+			return CheckNotice(*output, *format)
+		}
+		return Notice(*output, *format)
+	})
+	cmds.AddCommand(cmd)
+}
+
+// NoticeRepo is an entry of the attribution file, describing a single
+// vendored repository.
+type NoticeRepo struct {
+	ImportPath   string
+	Packages     []string
+	Revision     string
+	RevisionTime string
+	// Source is the fork from which the repository is vendored, if any (see
+	// VendorPackage's Source).
+	Source  string
+	License string
+	Files   []NoticeFile
+}
+
+type NoticeFile struct {
+	Name string
+	Text string
+}
+
+// BuildNotice collects notice entries for all repositories in pkgs, sorted by
+// import path. Contents of license files are read with readFile, which gets
+// slash-separated paths relative to project root.
+func BuildNotice(pkgs *VendorFile, readFile func(path string) ([]byte, error)) ([]*NoticeRepo, error) {
+	repos := []*NoticeRepo{}
+	byRoot := map[string]*NoticeRepo{}
+	for _, pkg := range pkgs.Packages {
+		repo := byRoot[pkg.RepositoryRoot]
+		if repo != nil {
+			repo.Packages = append(repo.Packages, pkg.Canonical)
+			continue
+		}
+		if pkg.License == "" {
+			return nil, fmt.Errorf("no license recorded for %s in %s (try running `vendo recreate`)", pkg.RepositoryRoot, JsonPath)
+		}
+		repo = &NoticeRepo{
+			ImportPath:   repositoryRootImportPath(pkg.RepositoryRoot),
+			Packages:     []string{pkg.Canonical},
+			Revision:     pkg.Revision,
+			RevisionTime: pkg.RevisionTime,
+			Source:       pkg.Source,
+			License:      pkg.License,
+		}
+		for _, name := range pkg.LicenseFiles {
+			data, err := readFile(path.Join(pkg.RepositoryRoot, name))
+			if err != nil {
+				return nil, fmt.Errorf("cannot read license file of %s: %s", pkg.RepositoryRoot, err)
+			}
+			repo.Files = append(repo.Files, NoticeFile{Name: name, Text: normalizeNoticeText(data)})
+		}
+		byRoot[pkg.RepositoryRoot] = repo
+		repos = append(repos, repo)
+	}
+	sort.Sort(noticeReposOrder(repos))
+	for _, repo := range repos {
+		sort.Strings(repo.Packages)
+	}
+	return repos, nil
+}
+
+// normalizeNoticeText converts line endings to "\n" and strips trailing
+// whitespace, so that output doesn't depend on platform of the upstream
+// authors.
+func normalizeNoticeText(data []byte) string {
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t\r")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+func FormatNotice(repos []*NoticeRepo, format string) []byte {
+	buf := &bytes.Buffer{}
+	if format == "markdown" {
+		fmt.Fprintf(buf, "# Third-party software notices\n\n")
+		fmt.Fprintf(buf, "This file lists third-party code vendored in `%s/`. It is generated by `vendo notice` from `%s`; DO NOT EDIT.\n",
+			VendorPath, JsonPath)
+		for _, repo := range repos {
+			fmt.Fprintf(buf, "\n## %s\n\n", repo.ImportPath)
+			fmt.Fprintf(buf, "- License: %s\n", repo.License)
+			fmt.Fprintf(buf, "- Revision: `%s` (%s)\n", repo.Revision, repo.RevisionTime)
+			if repo.Source != "" {
+				fmt.Fprintf(buf, "- Fork: vendored from `%s`\n", repo.Source)
+			}
+			fmt.Fprintf(buf, "- Packages:\n")
+			for _, pkg := range repo.Packages {
+				fmt.Fprintf(buf, "  - `%s`\n", pkg)
+			}
+			if len(repo.Files) == 0 {
+				fmt.Fprintf(buf, "\nNo license files found.\n")
+			}
+			for _, file := range repo.Files {
+				fmt.Fprintf(buf, "\n### %s\n\n```text\n%s\n```\n", file.Name, file.Text)
+			}
+		}
+		return buf.Bytes()
+	}
+
+	separator := strings.Repeat("=", 80)
+	fmt.Fprintf(buf, "THIRD-PARTY SOFTWARE NOTICES\n\n")
+	fmt.Fprintf(buf, "This file lists third-party code vendored in %s/. It is generated by\n'vendo notice' from %s; DO NOT EDIT.\n",
+		VendorPath, JsonPath)
+	for _, repo := range repos {
+		fmt.Fprintf(buf, "\n%s\n%s\n%s\n\n", separator, repo.ImportPath, separator)
+		fmt.Fprintf(buf, "License:  %s\n", repo.License)
+		fmt.Fprintf(buf, "Revision: %s (%s)\n", repo.Revision, repo.RevisionTime)
+		if repo.Source != "" {
+			fmt.Fprintf(buf, "Fork:     vendored from %s\n", repo.Source)
+		}
+		fmt.Fprintf(buf, "Packages:\n")
+		for _, pkg := range repo.Packages {
+			fmt.Fprintf(buf, "  %s\n", pkg)
+		}
+		if len(repo.Files) == 0 {
+			fmt.Fprintf(buf, "\nNo license files found.\n")
+		}
+		for _, file := range repo.Files {
+			fmt.Fprintf(buf, "\n--- %s ---\n\n%s\n", file.Name, file.Text)
+		}
+	}
+	return buf.Bytes()
+}
+
+func Notice(output, format string) error {
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		return err
+	}
+	if pkgs.Packages == nil {
+		return fmt.Errorf("file not found or empty: %s", JsonPath)
+	}
+	repos, err := BuildNotice(pkgs, func(path string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.FromSlash(path))
+	})
+	if err != nil {
+		return err
+	}
+	return writeFile(output, FormatNotice(repos, format))
+}
+
+// CheckNotice verifies that the attribution file in git's "staging area"
+// matches the one generated from vendor.json and license files in the
+// "staging area".
+func CheckNotice(output, format string) error {
+
+	// NOTE: this function operates strictly on files in git's "staging area" (index).
+	// ANY MODIFICATIONS MUST KEEP THIS INVARIANT.
+
+	// Make sure we're in project's root dir (with .git/, vendor.json, and _vendor/)
+	exist := Exist{}.GitDir(".git").File(JsonPath).Dir(VendorPath)
+	if exist.Err != nil {
+		return exist.Err
+	}
+
+	pkgs, err := ReadStagedVendorFile(JsonPath)
+	if err != nil {
+		return err
+	}
+	repos, err := BuildNotice(pkgs, readStaged)
+	if err != nil {
+		return err
+	}
+	current, err := readStaged(filepath.ToSlash(output))
+	if err != nil {
+		return fmt.Errorf("cannot read %s from git's staging area: %s (try running `vendo notice` and `git add %s`)", output, err, output)
+	}
+	if !bytes.Equal(current, FormatNotice(repos, format)) {
+		return fmt.Errorf("%s is out of date with %s (try running `vendo notice` and `git add %s`)", output, JsonPath, output)
+	}
+	return nil
+}
+
+// readStaged reads contents of file from git's "staging area".
+func readStaged(path string) ([]byte, error) {
+	r, err := git{}.ReadStaged(".", path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+type noticeReposOrder []*NoticeRepo
+
+func (p noticeReposOrder) Len() int           { return len(p) }
+func (p noticeReposOrder) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p noticeReposOrder) Less(i, j int) bool { return p[i].ImportPath < p[j].ImportPath }
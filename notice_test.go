@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_BuildNotice(test *testing.T) {
+	pkgs := &VendorFile{Packages: []*VendorPackage{
+		{Canonical: "github.com/z/z", RepositoryRoot: "_vendor/src/github.com/z/z", Revision: "2", License: UnknownLicense},
+		{Canonical: "github.com/a/a/sub", RepositoryRoot: "_vendor/src/github.com/a/a", Revision: "1",
+			License: "MIT", LicenseFiles: []string{"LICENSE"}},
+		{Canonical: "github.com/a/a", RepositoryRoot: "_vendor/src/github.com/a/a", Revision: "1",
+			License: "MIT", LicenseFiles: []string{"LICENSE"}},
+	}}
+	read := []string{}
+	repos, err := BuildNotice(pkgs, func(path string) ([]byte, error) {
+		read = append(read, path)
+		return []byte("\r\nMIT License  \r\n\r\nPermission...\r\n\r\n"), nil
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expected := []*NoticeRepo{
+		{ImportPath: "github.com/a/a", Packages: []string{"github.com/a/a", "github.com/a/a/sub"}, Revision: "1", License: "MIT",
+			Files: []NoticeFile{{Name: "LICENSE", Text: "MIT License\n\nPermission..."}}},
+		{ImportPath: "github.com/z/z", Packages: []string{"github.com/z/z"}, Revision: "2", License: UnknownLicense},
+	}
+	if !reflect.DeepEqual(repos, expected) {
+		test.Errorf("expected:\n%+v\n%+v\ngot:\n%+v\n%+v", *expected[0], *expected[1], *repos[0], *repos[1])
+	}
+	if !reflect.DeepEqual(read, []string{"_vendor/src/github.com/a/a/LICENSE"}) {
+		test.Errorf("expected license file read once, got: %q", read)
+	}
+
+	pkgs.Packages[0].License = ""
+	_, err = BuildNotice(pkgs, nil)
+	if err == nil {
+		test.Errorf("expected error for package without recorded license")
+	}
+}
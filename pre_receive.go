@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ImportPathConfig is the git config variable read by 'vendo pre-receive' if
+// flag --import-path is not provided.
+const ImportPathConfig = "vendo.importPath"
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "pre-receive",
+		Short: "for use as a git pre-receive hook on server",
+		Long: fmt.Sprintf(
+			`Pre-receive reads ref updates from stdin (in format described in 'git help
+githooks'), and runs all checks of 'vendo check' for each pushed commit not yet
+present in the repository. The push is rejected if any errors are found.
+
+It works also in bare repositories. As project's Go import path cannot be
+detected there, it must be provided with flag --import-path, or in git config:
+
+  git config %s example.com/project`,
+			ImportPathConfig),
+	}
+	importPath := cmd.Flags().String("import-path", "", "Go import path of the project (default from git config "+ImportPathConfig+")")
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		code, err := PreReceive(os.Stdin, *importPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			Exit(ExitInternal)
+		}
+		Exit(code)
+	}
+	cmds.AddCommand(cmd)
+}
+
+// PreReceive runs the checks for all commits pushed in ref updates read from
+// r, and prints results to stderr, which is shown by git to the pusher. It
+// returns exit code of the hook.
+func PreReceive(r io.Reader, importPath string) (int, error) {
+	repoDir, err := Command("git", "rev-parse", "--absolute-git-dir").
+		OutputOneLine()
+	if err != nil {
+		return 0, err
+	}
+	importPath, err = preReceiveImportPath(importPath)
+	if err != nil {
+		return 0, err
+	}
+	revs, err := parseRefUpdates(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(revs) == 0 {
+		return 0, nil
+	}
+
+	// Refs are not updated until the hook succeeds, so commits not reachable
+	// from any of them are the ones being pushed.
+	results, err := CheckRange(repoDir, importPath, append(revs, "--not", "--all")...)
+	if err != nil {
+		return 0, err
+	}
+	err = results.WriteText(os.Stderr)
+	if err != nil {
+		return 0, err
+	}
+	code := results.ExitCode()
+	if code != 0 {
+		fmt.Fprintf(os.Stderr, "vendo: push rejected, errors found in %s/ or %s (see `vendo help check`)\n",
+			VendorPath, JsonPath)
+	}
+	return code, nil
+}
+
+// preReceiveImportPath returns importPath, if not empty, or else the one set
+// in git config of the repository in current directory.
+func preReceiveImportPath(importPath string) (string, error) {
+	if importPath != "" {
+		return importPath, nil
+	}
+	importPath, err := Command("git", "config", "--get", ImportPathConfig).
+		LogNever().
+		OutputOneLine()
+	if err != nil || importPath == "" {
+		return "", fmt.Errorf("cannot detect import path of the project; use flag --import-path, or set git config %s",
+			ImportPathConfig)
+	}
+	return importPath, nil
+}
+
+// parseRefUpdates returns new revisions of refs updated (and not deleted) in
+// ref updates read from r.
+func parseRefUpdates(r io.Reader) ([]string, error) {
+	// Example input (see "git help githooks" -> "pre-receive"):
+	//
+	//	0000000000000000000000000000000000000000 3e4c1f5e1a2b... refs/heads/topic
+	//	9a0b8c7d6e5f... 3e4c1f5e1a2b... refs/heads/master
+	//	9a0b8c7d6e5f... 0000000000000000000000000000000000000000 refs/heads/old
+	revs := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected format of ref update: %q", scanner.Text())
+		}
+		newRevision := fields[1]
+		if strings.Trim(newRevision, "0") == "" {
+			// ref deleted
+			continue
+		}
+		revs = append(revs, newRevision)
+	}
+	return revs, scanner.Err()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func Test_parseRefUpdates(test *testing.T) {
+	zero := strings.Repeat("0", 40)
+	a, b, tag := strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40)
+	cases := []struct {
+		note     string
+		input    string
+		expected []string
+		isErr    bool
+	}{
+		{"empty", "", []string{}, false},
+		{"new branch", zero + " " + a + " refs/heads/topic\n", []string{a}, false},
+		{"updated branch", a + " " + b + " refs/heads/master\n", []string{b}, false},
+		{"deleted branch", a + " " + zero + " refs/heads/old\n", []string{}, false},
+		{"new tag", zero + " " + tag + " refs/tags/v1.0\n", []string{tag}, false},
+		{"many", zero + " " + a + " refs/heads/topic\n" + a + " " + zero + " refs/heads/old\n" + a + " " + b + " refs/heads/master",
+			[]string{a, b}, false},
+		{"malformed", a + " " + b + "\n", nil, true},
+	}
+	for _, c := range cases {
+		revs, err := parseRefUpdates(strings.NewReader(c.input))
+		if (err != nil) != c.isErr {
+			test.Errorf("%s: expected error: %v, got: %v", c.note, c.isErr, err)
+			continue
+		}
+		if !c.isErr && !reflect.DeepEqual(revs, c.expected) {
+			test.Errorf("%s: expected %q, got %q", c.note, c.expected, revs)
+		}
+	}
+}
+
+func Test_preReceiveImportPath(test *testing.T) {
+	dir, cleanup := testProject(test)
+	defer cleanup()
+	_, err := preReceiveImportPath("")
+	if err == nil {
+		test.Errorf("expected error without flag nor git config %s", ImportPathConfig)
+	}
+	testRun(test, dir, "git", "config", ImportPathConfig, "example.com/config")
+	for flag, expected := range map[string]string{"": "example.com/config", "example.com/flag": "example.com/flag"} {
+		importPath, err := preReceiveImportPath(flag)
+		if err != nil || importPath != expected {
+			test.Errorf("flag %q: expected %q, got %q, %v", flag, expected, importPath, err)
+		}
+	}
+}
+
+// Test_PreReceive_hook runs PreReceive when started by git as the pre-receive
+// hook in Test_PreReceive.
+func Test_PreReceive_hook(test *testing.T) {
+	if os.Getenv("VENDO_TEST_PRE_RECEIVE") == "" {
+		test.Skip("run by git from Test_PreReceive")
+	}
+	code, err := PreReceive(os.Stdin, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(ExitInternal)
+	}
+	os.Exit(code)
+}
+
+// Test_PreReceive pushes to a bare repository with vendo as pre-receive hook.
+func Test_PreReceive(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("needs sh")
+	}
+	upstream, cleanup := testVendoredProject(test, func(upstream string) string { return upstream })
+	defer cleanup()
+	binary, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		test.Fatal(err)
+	}
+	bare := filepath.Join(upstream, "..", "bare.git")
+	testRun(test, ".", "git", "init", "-q", "--bare", bare)
+	testRun(test, bare, "git", "config", ImportPathConfig, "example.com/proj")
+	hook := filepath.Join(bare, "hooks", "pre-receive")
+	writeTree(test, filepath.Dir(hook), map[string]string{
+		"pre-receive": fmt.Sprintf("#!/bin/sh\nVENDO_TEST_PRE_RECEIVE=1 exec '%s' -test.run='^Test_PreReceive_hook$'\n", binary),
+	})
+	err = os.Chmod(hook, 0755)
+	if err != nil {
+		test.Fatal(err)
+	}
+	push := func(note string, accepted bool, expected string, args ...string) {
+		// NOTE: CombinedOutput discards output on error.
+		out, err := Command("git", append([]string{"push", bare}, args...)...).Setenv(testGitEnv...).run()
+		if (err == nil) != accepted {
+			test.Errorf("%s: expected push accepted: %v, got: %v", note, accepted, err)
+		}
+		if !strings.Contains(string(out), expected) {
+			test.Errorf("%s: expected output containing %q, got:\n%s", note, expected, out)
+		}
+		if strings.Contains(string(out), "git stash") {
+			test.Errorf("%s: unexpected internal commands in output:\n%s", note, out)
+		}
+	}
+	commit := func(message string, files map[string]string) {
+		writeTree(test, ".", files)
+		testRun(test, ".", "git", "add", "-A")
+		testRun(test, ".", "git", "commit", "-q", "-m", message)
+	}
+
+	push("new branch", true, "vendor: ok", "master")
+	testRun(test, ".", "git", "tag", "-a", "-m", "v1", "v1.0")
+	push("tag", true, "", "v1.0")
+	commit("bad", map[string]string{"_vendor/src/example.com/dep/dep.go": "package dep\n\nfunc Hello() { println() }\n"})
+	push("bad commit", false, "bad: FAILED", "master")
+	testRun(test, ".", "git", "checkout", "-q", "--orphan", "docs")
+	testRun(test, ".", "git", "rm", "-q", "-r", "--cached", ".")
+	testRun(test, ".", "git", "clean", "-q", "-ffdx")
+	commit("docs", map[string]string{"README": "docs\n"})
+	push("branch without vendor.json", true, "docs: not vendored", "docs")
+	push("deleted branch", true, "", ":docs")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Keeps reports if file (slash-separated, relative to repository root) should
+// be added to the project when pruning a repository. pkgDirs are directories
+// of vendored packages in the repository, relative to its root ("." for the
+// root itself).
+func (p *PruneConfig) Keeps(file string, pkgDirs set) bool {
+	switch {
+	case matchPrunePatterns(p.Keep, file):
+		return true
+	case matchPrunePatterns(p.Drop, file):
+		return false
+	}
+	dir, name := path.Dir(file), path.Base(file)
+	if dir == "." && isLicenseFile(name) {
+		return true
+	}
+	_, found := pkgDirs[dir]
+	return found
+}
+
+func matchPrunePatterns(patterns []string, file string) bool {
+	elems := strings.Split(file, "/")
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			for i := range elems {
+				if ok, _ := path.Match(pattern, strings.Join(elems[:i+1], "/")); ok {
+					return true
+				}
+			}
+			continue
+		}
+		for _, elem := range elems {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pruneFiles returns those of files (slash-separated, relative to main repo
+// root) from repository root which are kept by prune (see PruneConfig.Keeps).
+func pruneFiles(root string, packages []*VendorPackage, prune *PruneConfig, files []string) []string {
+	pkgDirs := packageDirs(root, packages)
+	kept := []string{}
+	for _, file := range files {
+		if prune.Keeps(strings.TrimPrefix(file, root+"/"), pkgDirs) {
+			kept = append(kept, file)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "# %s: pruned %d of %d files\n", root, len(files)-len(kept), len(files))
+	return kept
+}
+
+// packageDirs returns directories of packages, relative to repository root
+// ("." for the root itself).
+func packageDirs(root string, packages []*VendorPackage) set {
+	pkgDirs := set{}
+	for _, pkg := range packages {
+		rel := strings.TrimPrefix(strings.TrimPrefix(path.Join(VendorPath, "src", pkg.Canonical), root), "/")
+		if rel == "" {
+			rel = "."
+		}
+		pkgDirs.Add(rel)
+	}
+	return pkgDirs
+}
+
+// prunedKeeps returns a function reporting if file (slash-separated, relative
+// to repository root) of pruned repository root is added to the project, per
+// "prune" config in pkgs (see PruneConfig.Keeps). Only those of the
+// repository's files may be missing on disk without being a local patch.
+func prunedKeeps(pkgs *VendorFile, root string) func(file string) bool {
+	prune := pkgs.Prune
+	if prune == nil {
+		prune = &PruneConfig{}
+	}
+	pkgDirs := packageDirs(root, repoPackages(pkgs.Packages)[root])
+	return func(file string) bool {
+		return prune.Keeps(file, pkgDirs)
+	}
+}
+
+// listRepositoryFiles returns slash-separated paths of all files in repository
+// root on disk, except VCS metadata (.git/.hg/.bzr; also ".git" files of git
+// submodules).
+func listRepositoryFiles(root string) ([]string, error) {
+	vcsDirs := set{}
+	for _, vcs := range vcsList {
+		vcsDirs.Add(vcs.Dir())
+	}
+	files := []string{}
+	err := filepath.Walk(filepath.FromSlash(root), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, isVcs := vcsDirs[info.Name()]; isVcs {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			files = append(files, filepath.ToSlash(path))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// isCleanInProject checks if repository root of pkg has no changes from
+// perspective of the main repo. For pruned repositories, files untracked in
+// the main repo are expected, unless pruning would keep them (see
+// prunedKeeps).
+func isCleanInProject(pkgs *VendorFile, pkg *VendorPackage) (bool, error) {
+	if !pkg.Pruned {
+		return git{}.IsClean(".", pkg.RepositoryRoot)
+	}
+	// NOTE: with -z, paths are not quoted, and are relative to the main repo root. Output format: "XY PATH" entries, with
+	// additional "ORIG_PATH" entry after renames and copies.
+	out, err := Command("git", "status", "--porcelain", "-z", "--untracked-files=all", "--", pkg.RepositoryRoot).
+		CombinedOutput()
+	if err != nil {
+		return false, err
+	}
+	keeps := prunedKeeps(pkgs, pkg.RepositoryRoot)
+	entries := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		if len(entry) < 4 || !strings.HasPrefix(entry, "?? ") {
+			return false, nil
+		}
+		// A file not added to the main repo, e.g. deleted there by a local patch, but restored on disk.
+		if keeps(strings.TrimPrefix(entry[3:], pkg.RepositoryRoot+"/")) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isCleanInRepo checks if repository root of pkg has no changes from the
+// revision checked out in its own VCS metadata. For pruned repositories, files
+// missing on disk are expected, unless pruning would keep them (see
+// prunedKeeps).
+func isCleanInRepo(vcs Vcs, pkgs *VendorFile, pkg *VendorPackage) (bool, error) {
+	if pkg.Pruned {
+		return vcs.IsCleanPruned(pkg.RepositoryRoot, ".", prunedKeeps(pkgs, pkg.RepositoryRoot))
+	}
+	return vcs.IsClean(pkg.RepositoryRoot, ".")
+}
+
+// repoPackages groups packages by their repository roots.
+func repoPackages(packages []*VendorPackage) map[string][]*VendorPackage {
+	byRoot := map[string][]*VendorPackage{}
+	for _, pkg := range packages {
+		byRoot[pkg.RepositoryRoot] = append(byRoot[pkg.RepositoryRoot], pkg)
+	}
+	return byRoot
+}
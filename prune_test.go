@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_PruneConfig_Keeps(test *testing.T) {
+	pkgDirs := set{}
+	pkgDirs.Add(".")
+	pkgDirs.Add("sub")
+	prune := &PruneConfig{
+		Keep: []string{"*.h", "internal/asm"},
+		Drop: []string{"*_test.go", "sub/gen.go"},
+	}
+	cases := map[string]bool{
+		"root.go":              true,
+		"LICENSE":              true,
+		"root_test.go":         false,
+		"sub/sub.go":           true,
+		"sub/LICENSE":          true,
+		"sub/gen.go":           false,
+		"sub/testdata/x.json":  false,
+		"examples/main.go":     false,
+		"docs/LICENSE":         false,
+		"cgo/include/foo.h":    true,
+		"internal/asm/a.s":     true,
+		"internal/asm/x/a.s":   true,
+		"internal/other/o.go":  false,
+		"internal/asm_test.go": false,
+	}
+	for file, expected := range cases {
+		if prune.Keeps(file, pkgDirs) != expected {
+			test.Errorf("Keeps(%q): expected %v", file, expected)
+		}
+	}
+}
+
+func Test_isCleanInProject_Pruned(test *testing.T) {
+	dir, cleanup := testProject(test)
+	defer cleanup()
+	root := "_vendor/src/example.com/a"
+	writeTree(test, dir, map[string]string{
+		root + "/a.go":          "package a\n",
+		root + "/b.go":          "package a\n",
+		root + "/LICENSE":       "MIT\n",
+		root + "/docs/index.md": "docs\n",
+	})
+	// Only the kept files are in the project, as after 'recreate' with pruning.
+	testRun(test, dir, "git", "add", root+"/a.go", root+"/b.go", root+"/LICENSE")
+	testRun(test, dir, "git", "commit", "-q", "-m", "vendor")
+	pkg := &VendorPackage{Canonical: "example.com/a", RepositoryRoot: root, Pruned: true}
+	pkgs := &VendorFile{Prune: &PruneConfig{}, Packages: []*VendorPackage{pkg}}
+	expectClean := func(note string, expected bool) {
+		clean, err := isCleanInProject(pkgs, pkg)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if clean != expected {
+			test.Errorf("%s: expected isCleanInProject=%v, got %v", note, expected, clean)
+		}
+	}
+	expectClean("pruned file untracked", true)
+
+	// A local patch deleting a kept file, which is then restored on disk from upstream revision.
+	testRun(test, dir, "git", "rm", "-q", root+"/b.go")
+	testRun(test, dir, "git", "commit", "-q", "-m", "patch")
+	expectClean("deleted file", true)
+	writeTree(test, dir, map[string]string{root + "/b.go": "package a\n"})
+	expectClean("deleted kept file restored", false)
+	os.Remove(root + "/b.go")
+
+	os.Remove(root + "/a.go")
+	expectClean("kept file missing", false)
+}
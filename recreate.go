@@ -34,13 +34,24 @@ func init() {
 transitively discovers imported packages.  If necessary, the packages are cloned
 from GOPATH to the %s/ directory, and appropriate entries are added to the %s
 file.  Finally, the results are added to staging area of the current repository,
-ready for commit.`,
-			VendorPath, JsonPath),
+ready for commit.
+
+Settings recorded in %s by earlier runs ("noTestDeps", "prune" and
+"vendorSubmodules") are kept, unless changed with flags --no-test-deps,
+--prune (or --no-prune) and --submodules.`,
+			VendorPath, JsonPath, JsonPath),
 	}
 	var (
 		platformsList = cmd.Flags().String("platforms", "", "format: OS_ARCH,OS_ARCH2[,...]")
-		clone         = cmd.Flags().Bool("clone", true, "if dependency doesn't exist in _vendor/, clone it from GOPATH")
+		clone         = cmd.Flags().Bool("clone", true, "if dependency doesn't exist in _vendor/, clone it from GOPATH (or from its \"source\" in "+JsonPath+")")
+		noTestDeps    = cmd.Flags().Bool("no-test-deps", false, "skip packages needed only by project's tests (e.g. for release snapshots)")
+		prune         = cmd.Flags().Bool("prune", false, "add only files of the needed packages and license files from each repository")
+		noPrune       = cmd.Flags().Bool("no-prune", false, "add all files from each repository, even if "+JsonPath+" has \"prune\" set")
+		pruneKeep     = cmd.Flags().String("prune-keep", "", "with --prune, additional file patterns to keep; format: PATTERN,PATTERN2[,...]")
+		pruneDrop     = cmd.Flags().String("prune-drop", "", "with --prune, file patterns to drop; format: PATTERN,PATTERN2[,...]")
+		submodules    = cmd.Flags().Bool("submodules", false, "vendor contents of git submodules as plain files (otherwise, repositories with submodules are an error)")
 	)
+	cmd.Flags().BoolVar(&DryRun, "dry-run", false, dryRunUsage)
 	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
 		if *platformsList == "" {
 			// FIXME(mateuszc): if empty, read Platforms from vendor.json; then if empty, return error (similar as in 'update' subcmd)
@@ -55,14 +66,38 @@ ready for commit.`,
 			return fmt.Errorf("non-empty '--platforms' argument must be provided")
 		}
 
-		return Recreate(platforms, *clone)
+		// Unless set with flags, keep the settings recorded in vendor.json, like 'update' does.
+		stored, err := ReadVendorFile(JsonPath)
+		if err != nil {
+			return err
+		}
+		if !cmd.Flags().Changed("no-test-deps") {
+			*noTestDeps = stored.NoTestDeps
+		}
+		if !cmd.Flags().Changed("submodules") {
+			*submodules = stored.VendorSubmodules
+		}
+		pruneConfig := stored.Prune
+		switch {
+		case *noPrune && (*prune || *pruneKeep != "" || *pruneDrop != ""):
+			return fmt.Errorf("flag --no-prune cannot be used with --prune, --prune-keep or --prune-drop")
+		case *noPrune:
+			pruneConfig = nil
+		case *prune || *pruneKeep != "" || *pruneDrop != "":
+			pruneConfig = &PruneConfig{
+				Keep: splitList(*pruneKeep),
+				Drop: splitList(*pruneDrop),
+			}
+		}
+
+		return Recreate(platforms, *clone, *noTestDeps, pruneConfig, *submodules)
 	})
 	cmds.AddCommand(cmd)
 }
 
-func Recreate(platforms []Platform, clone bool) error {
+func Recreate(platforms []Platform, clone, noTestDeps bool, prune *PruneConfig, vendorSubmodules bool) error {
 	// Make sure we're in project's root dir (with .git)
-	exist := Exist{}.Dir(".git")
+	exist := Exist{}.GitDir(".git")
 	if exist.Err != nil {
 		return exist.Err
 	}
@@ -77,25 +112,17 @@ func Recreate(platforms []Platform, clone bool) error {
 	// "VENDO-FORGET"
 	// (use-cases.md 1.5.1)
 
-	err = forget()
+	forgotten, err := forget()
 	if err != nil {
 		return err
 	}
+	defer forgotten()
 
 	// "VENDO-ADD"
 	// (use-cases.md 1.5.2)
 
-	os.MkdirAll(VendorPath, 0755) // Note: must be done before os.Stat(VendorPath)
-
-	// Find project's imports, excluding its own subpackages.
-	// TODO(mateuszc): we should remove in-project packages again before cloning, to protect if some external pkg depends back on the project
-	project, err := findProjectImportPath()
-	if err != nil {
-		return err
-	}
-	imports, err := findImportsGreedily(project)
-	if err != nil {
-		return err
+	if !DryRun {
+		os.MkdirAll(VendorPath, 0755) // Note: must be done before os.Stat(VendorPath)
 	}
 
 	// Prepare new Environ with: GOPATH=$PWD/_vendor:$GOPATH
@@ -103,23 +130,27 @@ func Recreate(platforms []Platform, clone bool) error {
 	if err != nil {
 		return err
 	}
+	// Clone repositories with "source" override (e.g. forks) which are missing in _vendor/, before crawling, so that they
+	// take precedence over GOPATH.
+	// (use-cases.md 1.5.2.4.1)
+	if clone {
+		err = cloneSources(pkgs)
+		if err != nil {
+			return err
+		}
+	}
+
 	// TODO(mateuszc): error if GOPATH is empty
 	gopath := vendorAbsPath + string(filepath.ListSeparator) + os.Getenv("GOPATH")
 
-	err = imports.addTransitiveDependencies(gopath, platforms)
-	if err != nil {
-		return err
-	}
-
-	err = imports.removeStdlibs()
+	// Find project's imports, excluding its own subpackages, and transitively their dependencies.
+	deps, err := crawlDependencies(gopath, platforms)
 	if err != nil {
 		return err
 	}
+	imports, needed := deps.Imports(noTestDeps)
 
-	missing, err := imports.findMissing(gopath)
-	if err != nil {
-		return err
-	}
+	missing := deps.FindMissing(imports)
 	if len(missing) > 0 {
 		return fmt.Errorf("cannot find dependency packages: %s in GOPATH=%s\nTry running:\n\tgo get %s",
 			missing, gopath, strings.Join(missing, " "))
@@ -132,8 +163,9 @@ func Recreate(platforms []Platform, clone bool) error {
 
 	// Clone missing pkgs to _vendor/ from GOPATH
 	// (use-cases.md 1.5.2.4.1)
+	clones := plannedClones{}
 	if clone {
-		err := imports.cloneNonVendoredPackages(vendorAbsPath)
+		clones, err = imports.cloneNonVendoredPackages(deps, vendorAbsPath)
 		if err != nil {
 			return err
 		}
@@ -141,33 +173,72 @@ func Recreate(platforms []Platform, clone bool) error {
 
 	// Verify that all dependency pkgs are now in _vendor/
 	// (use-cases.md 1.5.2.4.2)
-	missing, err = imports.findMissing(vendorAbsPath)
-	if err != nil {
-		return err
+	missing = []string{}
+	for _, imp := range NewResolver(deps.Project, vendorAbsPath).FindMissing(imports) {
+		if _, found := clones[imp]; !found {
+			missing = append(missing, imp)
+		}
 	}
 	if len(missing) > 0 {
 		return fmt.Errorf("cannot find the following packages in %s: %s",
 			vendorAbsPath, missing)
 	}
 
-	pkgsNew, err := imports.buildVendorFile(pkgs.ByCanonical())
+	// The origin stays the upstream repository; a fork checked out on disk is recorded as the source only.
+	sources, err := pkgs.repoSources()
+	if err != nil {
+		return err
+	}
+	upstreams := map[string]string{}
+	for _, pkg := range pkgs.Packages {
+		if pkg.Origin != "" && pkg.Origin != sources[pkg.RepositoryRoot] {
+			upstreams[pkg.RepositoryRoot] = pkg.Origin
+		}
+	}
+	pkgsNew, err := imports.buildVendorFile(pkgs.ByCanonical(), needed, clones)
 	if err != nil {
 		return err
 	}
+	for _, pkg := range pkgsNew.Packages {
+		pkg.Source = sources[pkg.RepositoryRoot]
+		if pkg.Source != "" && pkg.Origin == pkg.Source {
+			pkg.Origin = upstreams[pkg.RepositoryRoot]
+		}
+	}
 	pkgsNew.Comment = pkgs.Comment
 	pkgsNew.Platforms = platforms
+	pkgsNew.NoTestDeps = noTestDeps
+	pkgsNew.AllowedLicenses = pkgs.AllowedLicenses
+	pkgsNew.Prune = prune
+	for _, pkg := range pkgsNew.Packages {
+		pkg.Pruned = prune != nil
+	}
+	pkgsNew.VendorSubmodules = vendorSubmodules
 
-	err = gitAddPackages(pkgsNew.Packages)
+	err = handleSubmodules(pkgsNew.Packages, vendorSubmodules)
+	if err != nil {
+		return err
+	}
+
+	err = detectLicenses(pkgsNew.Packages)
+	if err != nil {
+		return err
+	}
+
+	err = gitAddPackages(pkgsNew.Packages, prune)
 	if err != nil {
 		return err
 	}
 
 	// Write the new vendor.json, and add it to Git
+	if DryRun {
+		planVendorFileChanges(pkgs, &pkgsNew)
+	}
 	err = pkgsNew.WriteTo(JsonPath)
 	if err != nil {
 		return err
 	}
-	err = Command("git", "add", "--", JsonPath).DiscardOutput()
+	err = Command("git", "add", "--", JsonPath).Mutating().DiscardOutput()
 	if err != nil {
 		return err
 	}
@@ -184,11 +255,28 @@ func Recreate(platforms []Platform, clone bool) error {
 
 // Make Git "forget" the _vendor/ dir contents
 // (use-cases.md 1.5.1.1)
-func forget() error {
+//
+// If vendo is interrupted before the returned function is called (i.e. before
+// the project is recreated), git's index and _vendor/.gitignore are restored,
+// so that the project is not left half-forgotten.
+func forget() (done func(), err error) {
+	defer Phase("forget")()
+	done = func() {}
+	if !DryRun {
+		index, err := Command("git", "rev-parse", "--git-path", "index").OutputOneLine()
+		if err != nil {
+			return nil, err
+		}
+		done, err = backupFiles(index, GitignorePath)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// TODO(mateuszc): move this down, just before we start doing first "git add"?
-	err := Command("git", "rm", "--cached", "-r", "--ignore-unmatch", "-q", VendorPath).DiscardOutput()
+	err = Command("git", "rm", "--cached", "-r", "--ignore-unmatch", "-q", VendorPath).Mutating().DiscardOutput()
 	if err != nil {
-		return err
+		done()
+		return nil, err
 	}
 
 	// Delete "_vendor/.gitignore".  We must do this to remove "/" line, which is expected to be present in "_vendor/.gitignore" as result
@@ -196,11 +284,12 @@ func forget() error {
 	// "vendo-add", as it can now work in a purely additive fashion.
 	// (use-cases.md 1.5.1.4)
 	// TODO(mateuszc): move this down, just before we start doing first "git add"?
-	err = os.Remove(GitignorePath)
-	if err != nil && !os.IsNotExist(err) {
-		return err
+	err = removeFile(GitignorePath)
+	if err != nil {
+		done()
+		return nil, err
 	}
-	return nil
+	return done, nil
 }
 
 func findProjectImportPath() (string, error) {
@@ -229,11 +318,12 @@ func (imports Imports) Add(elem string)   { set(imports).Add(elem) }
 // findImportsGreedily analyzes all "*.go" files (except `_*`, `.*`, `testdata`) for imports, regardless of GOOS and build tags.
 // *[Note]* Just ignoring GOOS and GOARCH here is simpler than trying to parse & match them. As to build tags, we specifically want to
 // cover all combinations of them, as we want to make sure *all ever* dependencies of our main project are found.
-// Imports starting with excludePrefix are skipped.
+// The result has one package per directory with "*.go" files. Imports starting with project are skipped. Imports found in "*_test.go"
+// files (i.e. TestImports and XTestImports in `go list` terms) are stored in TestImports.
 // (use-cases.md 1.5.2.1)
-func findImportsGreedily(excludePrefix string) (Imports, error) {
+func findImportsGreedily(project string) ([]*GraphPackage, error) {
 	fset := token.NewFileSet()
-	imports := Imports{}
+	byDir := map[string]*GraphPackage{}
 	err := filepath.Walk(".", func(path string, info os.FileInfo, extError error) error {
 		// Ignore: "testdata", "_*", ".*" (they're ignored by 'go build' too)
 		name := info.Name()
@@ -257,118 +347,90 @@ func findImportsGreedily(excludePrefix string) (Imports, error) {
 		if file == nil {
 			return nil
 		}
+		dir := filepath.Dir(path)
+		pkg := byDir[dir]
+		if pkg == nil {
+			absDir, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			pkg = &GraphPackage{
+				ImportPath: filepath.ToSlash(filepath.Join(project, dir)),
+				Dir:        absDir,
+				Project:    true,
+			}
+			byDir[dir] = pkg
+		}
 		for _, quotedImp := range file.Imports {
 			if quotedImp == nil || quotedImp.Path == nil {
 				// TODO(mateuszc): warn
 				continue
 			}
 			imp := strings.Trim(quotedImp.Path.Value, `"`)
-			if hasImportPrefix(imp, excludePrefix) {
+			if hasImportPrefix(imp, project) {
 				continue
 			}
-			imports.Add(imp)
+			site := Import{
+				Path: imp,
+				File: filepath.ToSlash(path),
+				Line: fset.Position(quotedImp.Pos()).Line,
+			}
+			if strings.HasSuffix(name, "_test.go") {
+				pkg.TestImports = append(pkg.TestImports, site)
+			} else {
+				pkg.Imports = append(pkg.Imports, site)
+			}
 		}
 		return nil
 	})
-	return imports, err
-}
-
-// addTransitiveDependencies builds a transitive list of import dependencies. If imported pkg is not found in GOPATH (including *_vendor*),
-// then function reports **error**, and exit. To build the import list we use `go list`, because it handles build tags (we assume that we
-// want all the imports built in "default" configuration, i.e. with no build tags). Finally, `go list` result depends on GOOS and GOARCH, so
-// we merge result from every GOOS & GOARCH combination (as listed in `-platforms` **mandatory** argument).
-// (use-cases.md 1.5.2.2)
-func (imports Imports) addTransitiveDependencies(gopath string, platforms []Platform) error {
-	if len(platforms) == 0 {
-		panic(`empty list of platforms in addTransitiveDependencies`)
-	}
-	for _, platform := range platforms {
-		// Add all transitive dependencies reported by 'go list'.
-		deps, err := GoList("{{range .Deps}}{{. | println}}{{end}}", imports.ToSlice()...).
-			WithFailed().
-			Setenv(
-			"GOPATH="+gopath,
-			"GOOS="+platform.Os,
-			"GOARCH="+platform.Arch).
-			OutputLines()
-		if err != nil {
-			return err
-		}
-		for _, imp := range deps {
-			// TODO(mateuszc): path.Clean()? e.g. for: import "foo//bar"
-			// FIXME(mateuszc): pkgs added here shouldn't "spill" to `go list` args for next platform
-			imports.Add(imp)
-		}
+	pkgs := []*GraphPackage{}
+	for _, pkg := range byDir {
+		pkgs = append(pkgs, pkg)
 	}
-	return nil
+	sort.Sort(graphPackagesOrder(pkgs))
+	return pkgs, err
 }
 
-// removeStdlibs removes standard library packages from the list.
-// go list -e -f '{{if .Standard}}{{.ImportPath}}{{end}}' pkg1 pkg2 ...
-func (imports Imports) removeStdlibs() error {
-	stdlibs, err := GoList("{{if .Standard}}{{.ImportPath}}{{end}}", imports.ToSlice()...).
-		WithFailed().
-		OutputLines()
+// crawlDependencies builds a transitive list of import dependencies of the project found in current directory. Packages are searched for
+// in gopath. We want all the imports built in "default" configuration, i.e. with no build tags, same as `go list`. The result depends on
+// GOOS and GOARCH, so an independent import graph is built for every GOOS & GOARCH combination (as listed in `-platforms` **mandatory**
+// argument), and each dependency records the platforms which need it.
+// (use-cases.md 1.5.2.2)
+func crawlDependencies(gopath string, platforms []Platform) (*Dependencies, error) {
+	defer Phase("crawl")()
+	project, err := findProjectImportPath()
 	if err != nil {
-		return err
-	}
-	for _, stdlib := range stdlibs {
-		delete(imports, stdlib)
+		return nil, err
 	}
-	return nil
-}
-
-// findMissing checks if there are any pkgs which can't be found in GOPATH (including _vendor/)
-func (imports Imports) findMissing(gopath string) ([]string, error) {
-	missing, err := GoList("{{if not .Root}}{{.ImportPath}}{{end}}", imports.ToSlice()...).
-		WithFailed().
-		Setenv(
-		"GOPATH=" + gopath).
-		OutputLines()
+	projectPkgs, err := findImportsGreedily(project)
 	if err != nil {
 		return nil, err
 	}
-	return missing, nil
+	return NewResolver(project, gopath).CrawlAll(platforms, projectPkgs)
 }
 
-func (imports Imports) cloneNonVendoredPackages(toGopath string) error {
-	// Find dependency pkgs which are not in _vendor/
-	pending, err := GoList("{{if not .Root}}{{.ImportPath}}{{end}}", imports.ToSlice()...).
-		WithFailed().
-		Setenv(
-		"GOPATH=" + toGopath).
-		OutputLines()
-	if err != nil {
-		return err
-	}
-	if len(pending) == 0 {
-		// `go list` won't handle empty list correctly, so we must quit explicitly.
-		return nil
-	}
-
-	// Find where they are.
-	// Note: go list will print different .Root than above, because we set different GOPATH environment variable
-	lines, err := GoList("{{.ImportPath}}\t{{.Root}}", pending...).
-		OutputLines()
-	if err != nil {
-		return err
-	}
-
-	// Do the cloning.
+// cloneNonVendoredPackages clones to toGopath any of imports which were found by deps in a different GOPATH entry. In DryRun
+// mode, the cloning is only planned, and the packages which would be cloned are returned.
+func (imports Imports) cloneNonVendoredPackages(deps *Dependencies, toGopath string) (plannedClones, error) {
+	defer Phase("clone")()
+	pending := imports.ToSlice()
+	sort.Strings(pending)
 	completed := map[string]bool{}
-	for i, line := range lines {
-		// Parse `go list` output
-		split := strings.SplitN(line, "\t", 2)
-		if len(split) != 2 {
-			return fmt.Errorf("cannot parse 'go list' line %d %q for cloning in:\n%s",
-				i+1, line, strings.Join(lines, "\n"))
-		}
-		err := clonePackage(split[0], split[1], toGopath, completed)
+	clones := plannedClones{}
+	for _, imp := range pending {
+		pkg := deps.Package(imp)
+		if pkg == nil || pkg.Root == toGopath {
+			continue
+		}
+		err := clonePackage(imp, pkg.Root, toGopath, completed)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if DryRun {
+			clones[imp] = filepath.Join(pkg.Root, "src", imp)
 		}
 	}
-	return nil
+	return clones, nil
 }
 
 func clonePackage(importPath, fromGopath, toGopath string, skipRepos map[string]bool) error {
@@ -393,6 +455,11 @@ func clonePackage(importPath, fromGopath, toGopath string, skipRepos map[string]
 		return err
 	}
 	toRepo := filepath.Join(toGopath, "src", importPath, rel)
+	if DryRun {
+		planned("clone %s repository %s to %s", vcs.Dir(), fromRepo, toRepo)
+		skipRepos[fromRepo] = true
+		return nil
+	}
 	err = os.MkdirAll(toRepo, 0755)
 	if err != nil {
 		return err
@@ -401,16 +468,45 @@ func clonePackage(importPath, fromGopath, toGopath string, skipRepos map[string]
 	if err != nil {
 		return err
 	}
-	// FIXME(mateuszc): overwrite the final repo's "remote/origin URL" to the same as used in source repo, to facilitate 'go get -u'
+	// The clone may be at a different revision than the one checked out in fromRepo (e.g. `bzr branch` uses the tip of the branch,
+	// not the working tree's revision).
+	fromRevision, err := vcs.Revision(fromRepo)
+	if err != nil {
+		return err
+	}
+	toRevision, err := vcs.Revision(toRepo)
+	if err != nil {
+		return err
+	}
+	if toRevision != fromRevision {
+		err = vcs.Checkout(toRepo, fromRevision)
+		if err != nil {
+			return err
+		}
+	}
+	// Use the same origin as the source repo (instead of fromRepo), to facilitate 'vendo update'.
+	origin, err := vcs.Origin(fromRepo)
+	if err != nil {
+		return err
+	}
+	if origin != "" {
+		err = vcs.SetOrigin(toRepo, origin)
+		if err != nil {
+			return err
+		}
+	}
 	skipRepos[fromRepo] = true
 	return nil
 }
 
 // buildVendorFile builds contents of new vendor.json file. It refreshes each dependency's
 // revision-id & revision-date from repository (if available), or copies them
-// from old vendor.json. If neither has it, reports error.
+// from old vendor.json. If neither has it, reports error. Each package's scope
+// and platforms are set from needed. Info about packages from clones (in DryRun mode) is read from their original
+// repositories.
 // (use-cases.md 1.5.2.4.4 - 1.5.2.4.5)
-func (imports Imports) buildVendorFile(pkgsMap map[string]*VendorPackage) (VendorFile, error) {
+func (imports Imports) buildVendorFile(pkgsMap map[string]*VendorPackage, needed map[string]*Dependency, clones plannedClones) (VendorFile, error) {
+	defer Phase("revisions")()
 	fmt.Println()
 	// pkgsMap := pkgs.MapCanonical()
 	pkgsNew := VendorFile{
@@ -418,10 +514,22 @@ func (imports Imports) buildVendorFile(pkgsMap map[string]*VendorPackage) (Vendo
 	}
 	for imp := range imports {
 		vendorImpDir := filepath.Join(VendorPath, "src", imp)
-		repoRoot, vcs, err := vcsList.FindRoot(vendorImpDir)
+		impDir, cloned := clones.Find(imp)
+		findRoot := vcsList.FindVendoredRoot
+		if cloned {
+			findRoot = vcsList.FindRoot
+		}
+		diskRoot, vcs, err := findRoot(impDir)
 		if err != nil {
 			return pkgsNew, err
 		}
+		repoRoot := diskRoot
+		if cloned && vcs != nil {
+			repoRoot, err = clones.VendorRoot(imp, diskRoot)
+			if err != nil {
+				return pkgsNew, err
+			}
+		}
 		if filepath.IsAbs(repoRoot) {
 			panic(fmt.Sprintf("internal error: repoRoot [%q] is absolute [VendorPath=%q]",
 				repoRoot, VendorPath))
@@ -434,7 +542,8 @@ func (imports Imports) buildVendorFile(pkgsMap map[string]*VendorPackage) (Vendo
 			// (use-cases.md 1.5.2.4.4.2)
 			if pkg == nil {
 				// FIXME(mateuszc): find if any parent dirs are in vendor.json
-				return pkgsNew, fmt.Errorf("cannot find repository root for pkg %s either in %s/ or in %s",
+				return pkgsNew, fmt.Errorf("cannot find repository root for pkg %s either in %s/ or in %s\n"+
+					"(if the repository was copied without version control metadata, try: vendo identify DIR)",
 					imp, VendorPath, JsonPath)
 			}
 			// TODO(mateuszc): update pkg.Local ?
@@ -450,15 +559,25 @@ func (imports Imports) buildVendorFile(pkgsMap map[string]*VendorPackage) (Vendo
 			pkg.Local = vendorImpDir
 			// RepositoryRoot should be OS-independent, so we use '/' as path separator
 			pkg.RepositoryRoot = filepath.ToSlash(repoRoot)
-			pkg.Revision, err = vcs.Revision(pkg.RepositoryRoot)
+			pkg.Revision, err = vcs.Revision(diskRoot)
 			if err != nil {
 				return pkgsNew, err
 			}
-			pkg.RevisionTime, err = vcs.RevisionTime(pkg.RepositoryRoot)
+			pkg.RevisionTime, err = vcs.RevisionTime(diskRoot)
 			if err != nil {
 				return pkgsNew, err
 			}
+			pkg.Vcs = vcsName(vcs)
+			origin, err := vcs.Origin(diskRoot)
+			if err != nil {
+				return pkgsNew, err
+			}
+			if origin != "" {
+				pkg.Origin = origin
+			}
 		}
+		pkg.Scope = needed[imp].Scope
+		pkg.Platforms = needed[imp].Platforms
 
 		pkgsNew.Packages = append(pkgsNew.Packages, pkg)
 	}
@@ -471,6 +590,10 @@ func (imports Imports) buildVendorFile(pkgsMap map[string]*VendorPackage) (Vendo
 // etc.) and without any "submodules" metadata.
 // (use-cases.md 1.5.2.3)
 func writeVcsGitignore() error {
+	if DryRun {
+		planned("write file: %s", GitignorePath)
+		return nil
+	}
 	gitignore, err := os.OpenFile(GitignorePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return err
@@ -492,19 +615,44 @@ func writeVcsGitignore() error {
 	return nil
 }
 
-// gitAddPackages adds contents of all dependency repositories to main project's repository.
+// gitAddPackages adds contents of all dependency repositories to main project's repository. If prune is not nil, only some of
+// the files are added (see pruneFiles).
 // (use-cases.md 1.5.2.4.6)
-func gitAddPackages(packages []*VendorPackage) error {
+func gitAddPackages(packages []*VendorPackage, prune *PruneConfig) error {
+	defer Phase("git add")()
 	added := map[string]bool{}
+	byRoot := repoPackages(packages)
 	for _, pkg := range packages {
 		if added[pkg.RepositoryRoot] {
 			continue
 		}
 
 		// Add the dependency repository to main project's repository.
-		// NOTE(mateuszc): the trailing "/" seems to make a world of a difference (as of git 2.1.):
-		// without "/", git seems to want to treat the dir as a submodule.
-		err := Command("git", "add", "--", pkg.RepositoryRoot+"/").DiscardOutput()
+		// NOTE: we can't just `git add $PKG_REPO_ROOT/`: newer versions of git (verified with 2.39) add a repository
+		// with its own .git/ subdir as a "gitlink" (i.e. as a submodule), instead of its files. So we list the files ourselves,
+		// skipping VCS metadata and anything ignored by git, and add them via gitAddFiles.
+		if _, err := os.Stat(pkg.RepositoryRoot); DryRun && os.IsNotExist(err) {
+			planned("add to git index: all files of %s, after cloning", pkg.RepositoryRoot)
+			added[pkg.RepositoryRoot] = true
+			continue
+		}
+		files, err := listRepositoryFiles(pkg.RepositoryRoot)
+		if err != nil {
+			return err
+		}
+		files, err = gitFilterIgnored(files)
+		if err != nil {
+			return err
+		}
+		if prune != nil {
+			files = pruneFiles(pkg.RepositoryRoot, byRoot[pkg.RepositoryRoot], prune, files)
+		}
+		if DryRun {
+			planned("add to git index: %d files of %s", len(files), pkg.RepositoryRoot)
+			added[pkg.RepositoryRoot] = true
+			continue
+		}
+		err = gitAddFiles(files)
 		if err != nil {
 			return err
 		}
@@ -517,6 +665,10 @@ func gitAddPackages(packages []*VendorPackage) error {
 // main project, but exist there e.g. because of user's GOPATH) are ignored by Git.
 // (use-cases.md 1.5.3)
 func modifyGitignoreFinal() error {
+	if DryRun {
+		planned("append to %s: / !.gitignore", GitignorePath)
+		return Command("git", "add", GitignorePath).Mutating().DiscardOutput()
+	}
 	gitignore, err := os.OpenFile(GitignorePath, os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return err
@@ -533,7 +685,7 @@ func modifyGitignoreFinal() error {
 		// FIXME(mateuszc): add more context to error msg
 		return err
 	}
-	err = Command("git", "add", GitignorePath).DiscardOutput()
+	err = Command("git", "add", GitignorePath).Mutating().DiscardOutput()
 	if err != nil {
 		return err
 	}
@@ -544,6 +696,14 @@ func hasImportPrefix(imp, prefix string) bool {
 	return imp == prefix || strings.HasPrefix(imp, prefix+"/")
 }
 
+// splitList splits a comma-separated list from a command-line flag.
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
 func parsePlatforms(platformsList string) ([]Platform, error) {
 	platforms := []Platform{}
 	if platformsList == "" {
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resolver finds transitive import dependencies of packages in-process, using
+// package go/build, instead of running `go list` subprocesses. A separate
+// build.Context is used for each platform (build configuration), but
+// directory listings, file contents, and imports parsed from files are cached
+// in the Resolver and shared between all the contexts.
+//
+// Resolver assumes that the files on disk don't change during its lifetime.
+type Resolver struct {
+	// Project is the import path of the main project. The project and its
+	// subpackages are never crawled as dependencies, even if some third-party
+	// package imports them.
+	Project string
+
+	gopath string
+	fset   *token.FileSet
+	dirs   map[string][]os.FileInfo
+	files  map[string][]byte
+	parsed map[string][]Import
+	found  map[[2]string]*build.Package
+}
+
+// Import describes a single import statement. It is the "reason" why the
+// imported package is needed.
+type Import struct {
+	// Path of the imported package. In a Graph, this is the import path after
+	// resolving any "vendor" directories.
+	Path string `json:"path"`
+	// File containing the import statement. For project packages, it is
+	// relative to the project root, otherwise absolute. It always uses
+	// forward slashes.
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// GraphPackage is a node of Graph.
+type GraphPackage struct {
+	ImportPath string `json:"importPath"`
+	// Dir is the directory with package's source files. It is empty if the
+	// package was not found.
+	Dir string `json:"dir,omitempty"`
+	// Root is the GOROOT or GOPATH entry where the package was found.
+	Root     string `json:"root,omitempty"`
+	Standard bool   `json:"standard,omitempty"`
+	Project  bool   `json:"project,omitempty"`
+	// Imports lists the imports from package's files which are included in
+	// the build. Imports of standard packages are not listed.
+	Imports []Import `json:"imports,omitempty"`
+	// TestImports lists the imports from "*_test.go" files. Set only for
+	// Project packages.
+	TestImports []Import `json:"testImports,omitempty"`
+}
+
+// Graph is a full import graph of the project, built for a single platform.
+type Graph struct {
+	Platform Platform                 `json:"platform"`
+	Packages map[string]*GraphPackage `json:"packages"`
+}
+
+func NewResolver(project, gopath string) *Resolver {
+	return &Resolver{
+		Project: project,
+		gopath:  gopath,
+		fset:    token.NewFileSet(),
+		dirs:    map[string][]os.FileInfo{},
+		files:   map[string][]byte{},
+		parsed:  map[string][]Import{},
+		found:   map[[2]string]*build.Package{},
+	}
+}
+
+// Context returns a build.Context for platform, with file access going
+// through the resolver's caches.
+func (r *Resolver) Context(platform Platform) *build.Context {
+	ctxt := build.Default
+	ctxt.GOOS = platform.Os
+	ctxt.GOARCH = platform.Arch
+	ctxt.GOPATH = r.gopath
+	// Same as the go tool, disable cgo by default when cross-compiling.
+	ctxt.CgoEnabled = build.Default.CgoEnabled &&
+		platform.Os == runtime.GOOS && platform.Arch == runtime.GOARCH
+	// NOTE: setting the hooks also makes go/build search GOPATH by
+	// itself, instead of trying to call `go list` when modules are enabled.
+	ctxt.ReadDir = r.readDir
+	ctxt.OpenFile = r.openFile
+	return &ctxt
+}
+
+func (r *Resolver) readDir(dir string) ([]os.FileInfo, error) {
+	infos, found := r.dirs[dir]
+	if found {
+		return infos, nil
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	r.dirs[dir] = infos
+	return infos, nil
+}
+
+func (r *Resolver) readFile(path string) ([]byte, error) {
+	data, found := r.files[path]
+	if found {
+		return data, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r.files[path] = data
+	return data, nil
+}
+
+func (r *Resolver) openFile(path string) (io.ReadCloser, error) {
+	data, err := r.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// parseImports returns imports found in a *.go file, as written in source.
+func (r *Resolver) parseImports(path string) ([]Import, error) {
+	imports, found := r.parsed[path]
+	if found {
+		return imports, nil
+	}
+	data, err := r.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := parser.ParseFile(r.fset, path, data, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	imports = []Import{}
+	for _, spec := range file.Imports {
+		imp, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad import %s", r.fset.Position(spec.Pos()), spec.Path.Value)
+		}
+		imports = append(imports, Import{
+			Path: imp,
+			File: filepath.ToSlash(path),
+			Line: r.fset.Position(spec.Pos()).Line,
+		})
+	}
+	r.parsed[path] = imports
+	return imports, nil
+}
+
+// find locates the directory of package imported as path from srcDir. If the
+// package is not found, returned Dir is empty. Result doesn't depend on the
+// platform, so it is shared by all contexts.
+func (r *Resolver) find(ctxt *build.Context, path, srcDir string) *build.Package {
+	key := [2]string{path, srcDir}
+	pkg, found := r.found[key]
+	if found {
+		return pkg
+	}
+	pkg, err := ctxt.Import(path, srcDir, build.FindOnly)
+	if err != nil && pkg.Dir == "" {
+		pkg = &build.Package{ImportPath: path}
+	}
+	r.found[key] = pkg
+	return pkg
+}
+
+// Crawl builds the import graph for platform. The graph starts from project
+// packages (see findImportsGreedily), and includes all packages transitively
+// imported by them, including via test imports. Standard library packages are
+// added to the graph, but their imports are not followed.
+func (r *Resolver) Crawl(platform Platform, project []*GraphPackage) (*Graph, error) {
+	ctxt := r.Context(platform)
+	g := &Graph{
+		Platform: platform,
+		Packages: map[string]*GraphPackage{},
+	}
+
+	// Resolve imports of the project packages and enqueue them for crawling.
+	pending := []string{}
+	resolve := func(imports []Import, srcDir string) []Import {
+		resolved := []Import{}
+		for _, imp := range imports {
+			if imp.Path == "C" || build.IsLocalImport(imp.Path) {
+				// TODO: warn about local imports
+				continue
+			}
+			found := r.find(ctxt, imp.Path, srcDir)
+			if hasImportPrefix(found.ImportPath, r.Project) {
+				continue
+			}
+			imp.Path = found.ImportPath
+			resolved = append(resolved, imp)
+			if g.Packages[imp.Path] == nil {
+				g.Packages[imp.Path] = &GraphPackage{
+					ImportPath: found.ImportPath,
+					Dir:        found.Dir,
+					Root:       found.Root,
+					Standard:   found.Goroot,
+				}
+				pending = append(pending, imp.Path)
+			}
+		}
+		return resolved
+	}
+	for _, p := range project {
+		pkg := *p
+		pkg.Imports = resolve(p.Imports, p.Dir)
+		pkg.TestImports = resolve(p.TestImports, p.Dir)
+		g.Packages[pkg.ImportPath] = &pkg
+	}
+
+	// Crawl dependencies transitively.
+	for len(pending) > 0 {
+		pkg := g.Packages[pending[0]]
+		pending = pending[1:]
+		if pkg.Standard || pkg.Dir == "" {
+			continue
+		}
+		imports, err := r.buildImports(ctxt, pkg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		pkg.Imports = resolve(imports, pkg.Dir)
+	}
+	return g, nil
+}
+
+// buildImports returns imports from files in dir which would be included in
+// a build using ctxt, excluding tests.
+func (r *Resolver) buildImports(ctxt *build.Context, dir string) ([]Import, error) {
+	infos, err := r.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	imports := []Import{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		match, err := ctxt.MatchFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			continue
+		}
+		fileImports, err := r.parseImports(filepath.Join(dir, name))
+		if err != nil {
+			// TODO(mateuszc): more detailed error (with file & line) if necessary
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			continue
+		}
+		if !ctxt.CgoEnabled && importsC(fileImports) {
+			// The go tool ignores cgo files when cgo is disabled.
+			continue
+		}
+		imports = append(imports, fileImports...)
+	}
+	return imports, nil
+}
+
+func importsC(imports []Import) bool {
+	for _, imp := range imports {
+		if imp.Path == "C" {
+			return true
+		}
+	}
+	return false
+}
+
+// CrawlAll builds independent import graphs for each of the platforms.
+//
+// Project packages were found greedily, regardless of build constraints. For
+// each platform, only imports from project files matching the platform's
+// build constraints are crawled. To still find *all ever* dependencies of the
+// project, imports from files not matching any of the platforms (e.g. because
+// of custom build tags) are crawled for all platforms.
+func (r *Resolver) CrawlAll(platforms []Platform, project []*GraphPackage) (*Dependencies, error) {
+	if len(platforms) == 0 {
+		panic(`empty list of platforms in CrawlAll`)
+	}
+	contexts := []*build.Context{}
+	for _, platform := range platforms {
+		contexts = append(contexts, r.Context(platform))
+	}
+
+	// Find which project files are built on which platforms.
+	matches := map[string][]bool{}
+	for _, pkg := range project {
+		for _, imports := range [][]Import{pkg.Imports, pkg.TestImports} {
+			for _, imp := range imports {
+				if matches[imp.File] != nil {
+					continue
+				}
+				match, any := make([]bool, len(platforms)), false
+				for i, ctxt := range contexts {
+					ok, err := ctxt.MatchFile(pkg.Dir, path.Base(imp.File))
+					if err != nil {
+						return nil, err
+					}
+					match[i] = ok
+					any = any || ok
+				}
+				if !any {
+					for i := range match {
+						match[i] = true
+					}
+				}
+				matches[imp.File] = match
+			}
+		}
+	}
+	filter := func(imports []Import, i int) []Import {
+		filtered := []Import{}
+		for _, imp := range imports {
+			if matches[imp.File][i] {
+				filtered = append(filtered, imp)
+			}
+		}
+		return filtered
+	}
+
+	deps := &Dependencies{Project: r.Project}
+	for i, platform := range platforms {
+		platformProject := []*GraphPackage{}
+		for _, p := range project {
+			pkg := *p
+			pkg.Imports = filter(p.Imports, i)
+			pkg.TestImports = filter(p.TestImports, i)
+			platformProject = append(platformProject, &pkg)
+		}
+		g, err := r.Crawl(platform, platformProject)
+		if err != nil {
+			return nil, err
+		}
+		deps.Graphs = append(deps.Graphs, g)
+	}
+	return deps, nil
+}
+
+// FindMissing returns those of imports which cannot be found in resolver's
+// GOPATH. The result doesn't depend on platform.
+func (r *Resolver) FindMissing(imports Imports) []string {
+	ctxt := r.Context(Platform{Os: runtime.GOOS, Arch: runtime.GOARCH})
+	missing := []string{}
+	for imp := range imports {
+		pkg := r.find(ctxt, imp, "")
+		if pkg.Dir == "" {
+			missing = append(missing, imp)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Scopes classifies all dependencies (i.e. non-standard, non-project
+// packages) in the graph. Packages reachable from non-test imports of the
+// project are ScopeBuild, other ones imported directly by project's tests are
+// ScopeTest, and remaining ones are ScopeTransitiveTest.
+func (g *Graph) Scopes() map[string]Scope {
+	scopes := map[string]Scope{}
+	var visit func(imports []Import, scope Scope)
+	visit = func(imports []Import, scope Scope) {
+		for _, imp := range imports {
+			pkg := g.Packages[imp.Path]
+			if pkg.Standard || scopes[imp.Path] != "" {
+				continue
+			}
+			scopes[imp.Path] = scope
+			visit(pkg.Imports, scope)
+		}
+	}
+	project := g.projectPackages()
+	for _, pkg := range project {
+		visit(pkg.Imports, ScopeBuild)
+	}
+	for _, pkg := range project {
+		for _, imp := range pkg.TestImports {
+			if scopes[imp.Path] == "" && !g.Packages[imp.Path].Standard {
+				scopes[imp.Path] = ScopeTest
+			}
+		}
+	}
+	for _, pkg := range project {
+		for _, imp := range pkg.TestImports {
+			visit(g.Packages[imp.Path].Imports, ScopeTransitiveTest)
+		}
+	}
+	return scopes
+}
+
+func (g *Graph) projectPackages() []*GraphPackage {
+	project := []*GraphPackage{}
+	for _, pkg := range g.Packages {
+		if pkg.Project {
+			project = append(project, pkg)
+		}
+	}
+	sort.Sort(graphPackagesOrder(project))
+	return project
+}
+
+type graphPackagesOrder []*GraphPackage
+
+func (p graphPackagesOrder) Len() int           { return len(p) }
+func (p graphPackagesOrder) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p graphPackagesOrder) Less(i, j int) bool { return p[i].ImportPath < p[j].ImportPath }
+
+// Dependencies is the result of crawling project's dependencies for all
+// platforms.
+type Dependencies struct {
+	// Project is the import path of the main project.
+	Project string   `json:"project"`
+	Graphs  []*Graph `json:"graphs"`
+}
+
+// Dependency describes why a package is needed by the project.
+type Dependency struct {
+	Scope Scope
+	// Platforms lists platforms on which the package is needed.
+	Platforms []Platform
+}
+
+// Imports returns all dependencies of the project, together with their scopes
+// and platforms on which they are needed. If a package has different scopes on
+// different platforms, the most important one is chosen (ScopeBuild, then
+// ScopeTest, then ScopeTransitiveTest). If noTestDeps is true, only packages
+// in ScopeBuild are returned, and their platforms include only the ones where
+// they are in ScopeBuild.
+func (d *Dependencies) Imports(noTestDeps bool) (Imports, map[string]*Dependency) {
+	imports := Imports{}
+	result := map[string]*Dependency{}
+	for _, g := range d.Graphs {
+		for imp, scope := range g.Scopes() {
+			if noTestDeps && scope != ScopeBuild {
+				continue
+			}
+			dep := result[imp]
+			if dep == nil {
+				dep = &Dependency{}
+				result[imp] = dep
+				imports.Add(imp)
+			}
+			dep.Scope = mergeScope(dep.Scope, scope)
+			dep.Platforms = append(dep.Platforms, g.Platform)
+		}
+	}
+	return imports, result
+}
+
+// Package returns the first node for importPath found in any of the graphs,
+// or nil.
+func (d *Dependencies) Package(importPath string) *GraphPackage {
+	for _, g := range d.Graphs {
+		pkg := g.Packages[importPath]
+		if pkg != nil {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// FindMissing returns those of imports which were not found when crawling.
+func (d *Dependencies) FindMissing(imports Imports) []string {
+	missing := []string{}
+	for imp := range imports {
+		pkg := d.Package(imp)
+		if pkg == nil || pkg.Dir == "" {
+			missing = append(missing, imp)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// mergeScope returns the more important of scopes a and b (ScopeBuild, then
+// ScopeTest, then ScopeTransitiveTest).
+func mergeScope(a, b Scope) Scope {
+	rank := map[Scope]int{ScopeBuild: 3, ScopeTest: 2, ScopeTransitiveTest: 1}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree creates files with specified contents under root. Paths must be
+// slash-separated.
+func writeTree(test *testing.T, root string, files map[string]string) {
+	for path, contents := range files {
+		path = filepath.Join(root, filepath.FromSlash(path))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			test.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			test.Fatal(err)
+		}
+	}
+}
+
+func Test_Resolver_Crawl(test *testing.T) {
+	gopath, err := ioutil.TempDir("", "vendo-resolver")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	writeTree(test, gopath, map[string]string{
+		"src/example.com/a/a.go":         "package a\nimport (\n\t\"fmt\"\n\t\"example.com/b\"\n)\n",
+		"src/example.com/a/a_windows.go": "package a\nimport \"example.com/w\"\n",
+		"src/example.com/a/a_test.go":    "package a\nimport \"example.com/atest\"\n",
+		"src/example.com/b/b.go":         "package b\nimport \"example.com/proj/sub\"\n",
+		"src/example.com/w/w.go":         "package w\n",
+		"src/example.com/t/t.go":         "package t\nimport \"example.com/tt\"\n",
+		"src/example.com/tt/tt.go":       "package tt\n",
+		"src/example.com/ww/ww.go":       "package ww\n",
+		"src/example.com/i/i.go":         "package i\n",
+		// Project files must exist on disk, for matching build constraints.
+		"src/example.com/proj/proj.go":         "package proj\n",
+		"src/example.com/proj/proj_windows.go": "package proj\n",
+		"src/example.com/proj/tagged.go":       "// +build integration\n\npackage proj\n",
+		"src/example.com/proj/proj_test.go":    "package proj\n",
+	})
+	project := []*GraphPackage{{
+		ImportPath: "example.com/proj",
+		Dir:        filepath.Join(gopath, "src/example.com/proj"),
+		Project:    true,
+		Imports: []Import{
+			{Path: "example.com/a", File: "proj.go", Line: 3},
+			{Path: "example.com/missing", File: "proj.go", Line: 4},
+			{Path: "os", File: "proj.go", Line: 5},
+			{Path: "example.com/ww", File: "proj_windows.go", Line: 3},
+			{Path: "example.com/i", File: "tagged.go", Line: 5},
+		},
+		TestImports: []Import{
+			{Path: "example.com/a", File: "proj_test.go", Line: 3},
+			{Path: "example.com/t", File: "proj_test.go", Line: 4},
+		},
+	}}
+
+	r := NewResolver("example.com/proj", gopath)
+	deps, err := r.CrawlAll([]Platform{{"linux", "amd64"}, {"windows", "amd64"}}, project)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expected := []map[string]Scope{
+		{
+			"example.com/a":       ScopeBuild,
+			"example.com/b":       ScopeBuild,
+			"example.com/i":       ScopeBuild,
+			"example.com/missing": ScopeBuild,
+			"example.com/t":       ScopeTest,
+			"example.com/tt":      ScopeTransitiveTest,
+		},
+		{
+			"example.com/a":       ScopeBuild,
+			"example.com/b":       ScopeBuild,
+			"example.com/i":       ScopeBuild,
+			"example.com/w":       ScopeBuild,
+			"example.com/ww":      ScopeBuild,
+			"example.com/missing": ScopeBuild,
+			"example.com/t":       ScopeTest,
+			"example.com/tt":      ScopeTransitiveTest,
+		},
+	}
+	for i, g := range deps.Graphs {
+		scopes := g.Scopes()
+		if !reflect.DeepEqual(scopes, expected[i]) {
+			test.Errorf("platform %v expected scopes:\n%v\ngot:\n%v", g.Platform, expected[i], scopes)
+		}
+		if !g.Packages["os"].Standard {
+			test.Errorf("platform %v expected package os to be Standard", g.Platform)
+		}
+		if g.Packages["example.com/proj/sub"] != nil {
+			test.Errorf("platform %v expected project subpackage to be skipped", g.Platform)
+		}
+	}
+
+	imports, needed := deps.Imports(false)
+	windowsOnly := []Platform{{"windows", "amd64"}}
+	if !reflect.DeepEqual(needed["example.com/ww"].Platforms, windowsOnly) {
+		test.Errorf("expected example.com/ww needed on %v, got: %v", windowsOnly, needed["example.com/ww"].Platforms)
+	}
+	if len(needed["example.com/i"].Platforms) != 2 {
+		test.Errorf("expected example.com/i needed on all platforms, got: %v", needed["example.com/i"].Platforms)
+	}
+
+	imports, _ = deps.Imports(true)
+	missing := deps.FindMissing(imports)
+	if !reflect.DeepEqual(missing, []string{"example.com/missing"}) {
+		test.Errorf("expected missing [example.com/missing], got: %q", missing)
+	}
+	if _, found := imports["example.com/t"]; found {
+		test.Errorf("expected test dependencies to be skipped with noTestDeps, got: %q", imports.ToSlice())
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// repoSources returns the "source" overrides of repositories in v, keyed by
+// repository root. Packages of a repository without "source" inherit it from
+// the other packages, but different values are an error.
+func (v *VendorFile) repoSources() (map[string]string, error) {
+	sources := map[string]string{}
+	for _, pkg := range v.Packages {
+		if pkg.Source == "" {
+			continue
+		}
+		if source, found := sources[pkg.RepositoryRoot]; found && source != pkg.Source {
+			return nil, fmt.Errorf(`different "source" for packages of repository %s in %s: %q and %q`,
+				pkg.RepositoryRoot, JsonPath, source, pkg.Source)
+		}
+		sources[pkg.RepositoryRoot] = pkg.Source
+	}
+	return sources, nil
+}
+
+// cloneSources clones to _vendor/ repositories of pkgs, which have a "source"
+// override (e.g. a fork) but are missing on disk. The revision recorded in
+// vendor.json is checked out, if any; otherwise, the default head of source.
+// If a mirror of the source exists (see 'vendo mirror'), it's cloned instead.
+// Repositories on disk, which were cloned from elsewhere, are switched to the
+// source (see fetchSource).
+// (use-cases.md 1.5.2.4.1)
+func cloneSources(pkgs *VendorFile) error {
+	sources, err := pkgs.repoSources()
+	if err != nil {
+		return err
+	}
+	byRoot := pkgs.ByRepositoryRoot()
+	roots := []string{}
+	for root := range sources {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	for _, root := range roots {
+		if _, err := os.Stat(root); err == nil {
+			// Contents of _vendor/ take precedence, as in 'recreate'.
+			err = fetchSource(root, sources[root])
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		pkg, source := byRoot[root], sources[root]
+		vcs := vcsList.ByName(pkg.Vcs)
+		if vcs == nil {
+			// A local source can be checked.
+			vcs, err = vcsList.IsRoot(source)
+			if err != nil {
+				return err
+			}
+			if vcs == nil {
+				return fmt.Errorf(`cannot detect version control system of "source": %q (set "vcs" for %s in %s)`,
+					source, root, JsonPath)
+			}
+		}
+		err = cloneSource(root, source, vcs, pkg.Revision)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cloneSource(root, source string, vcs Vcs, revision string) error {
+	from, err := sourceMirror(vcs, source)
+	if err != nil {
+		return err
+	}
+	if DryRun {
+		planned("clone %s repository %s to %s, at revision: %s", vcsName(vcs), source, root, revision)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "# %s clone %s %s\n", vcsName(vcs), from, root)
+	err = os.MkdirAll(root, 0755)
+	if err != nil {
+		return err
+	}
+	err = vcs.Clone(from, root)
+	if err != nil {
+		return err
+	}
+	if from != source {
+		err = vcs.SetOrigin(root, source)
+		if err != nil {
+			return err
+		}
+	}
+	if revision == "" {
+		return nil
+	}
+	err = vcs.Checkout(root, revision)
+	if err != nil {
+		return fmt.Errorf("cannot checkout revision %s of %s (from %s): %s", revision, filepath.ToSlash(root), source, err)
+	}
+	return nil
+}
+
+// fetchSource fetches source into the repository at root, and makes it the
+// origin of the repository, if it was cloned from elsewhere (e.g. "source"
+// was set after vendoring the upstream). The checked out files are not
+// changed, so local patches are kept; 'vendo update' then updates to the
+// default head of source. Repositories without .git/.hg/.bzr subdir are
+// skipped.
+func fetchSource(root, source string) error {
+	vcs, err := vcsList.IsRoot(root)
+	if err != nil || vcs == nil {
+		return err
+	}
+	origin, err := vcs.Origin(root)
+	if err != nil || origin == source {
+		return err
+	}
+	from, err := sourceMirror(vcs, source)
+	if err != nil {
+		return err
+	}
+	if DryRun {
+		planned("fetch %s repository %s into %s (cloned from %q), and set it as origin", vcsName(vcs), source, root, origin)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "# %s: cloned from %q, switching to \"source\": %s\n", filepath.ToSlash(root), origin, source)
+	if _, ok := vcs.(bazaar); ok {
+		// NOTE: `bzr pull` would change the checked out files too, so the branch is pulled by 'vendo update' only.
+		return vcs.SetOrigin(root, source)
+	}
+	fmt.Fprintf(os.Stderr, "# %s fetch %s\n", vcsName(vcs), from)
+	_, err = vcs.Fetch(root, from, "")
+	if err != nil {
+		return err
+	}
+	if from != source {
+		return vcs.SetOrigin(root, source)
+	}
+	return nil
+}
+
+// sourceMirror returns the path of the mirror of source (see 'vendo mirror'),
+// if it exists, or source otherwise.
+func sourceMirror(vcs Vcs, source string) (string, error) {
+	mirror, err := findMirror(vcs, source)
+	if err != nil {
+		return "", err
+	}
+	if mirror != nil && mirror.Exists() {
+		return mirror.Path, nil
+	}
+	return source, nil
+}
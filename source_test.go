@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_repoSources(test *testing.T) {
+	pkgs := &VendorFile{Packages: []*VendorPackage{
+		{Canonical: "github.com/a/a", RepositoryRoot: "_vendor/src/github.com/a/a"},
+		{Canonical: "github.com/a/a/sub", RepositoryRoot: "_vendor/src/github.com/a/a", Source: "https://github.com/fork/a"},
+		{Canonical: "github.com/b/b", RepositoryRoot: "_vendor/src/github.com/b/b"},
+	}}
+	sources, err := pkgs.repoSources()
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected := map[string]string{"_vendor/src/github.com/a/a": "https://github.com/fork/a"}
+	if !reflect.DeepEqual(sources, expected) {
+		test.Errorf("expected %v, got %v", expected, sources)
+	}
+
+	pkgs.Packages[0].Source = "https://github.com/other/a"
+	_, err = pkgs.repoSources()
+	if err == nil {
+		test.Error("expected error for different sources of one repository")
+	}
+}
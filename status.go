@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: fmt.Sprintf("list packages vendored in %s/", VendorPath),
+		Long: fmt.Sprintf(
+			`Status prints the packages listed in %s file, with their revisions,
+and notes why they are needed: by production code or tests, and on which
+platforms (if not all). Packages vendored from a fork (with "source" override)
+are marked with "fork:" and the source.`,
+			JsonPath),
+	}
+	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		return Status()
+	})
+	cmds.AddCommand(cmd)
+}
+
+func Status() error {
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		return err
+	}
+	if pkgs.Packages == nil {
+		return fmt.Errorf("file not found or empty: %s", JsonPath)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, pkg := range pkgs.Packages {
+		revision := pkg.Revision
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		scope := pkg.Scope
+		if scope == "" {
+			scope = "?"
+		}
+		note := pkgs.PlatformsNote(pkg)
+		if pkg.Source != "" {
+			note += "\tfork: " + pkg.Source
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			pkg.Canonical, revision, pkg.RevisionTime, scope, note)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// handleSubmodules detects vendored git repositories with submodules (i.e. with
+// a ".gitmodules" file in repository root). If vendorSubmodules is false, such
+// repositories are reported as error. Otherwise, the submodules are checked
+// out recursively (so that their contents can be added as plain files by
+// gitAddPackages), and their revisions are recorded in packages.
+// Repositories without .git/ subdir keep the submodules info from old
+// vendor.json.
+func handleSubmodules(packages []*VendorPackage, vendorSubmodules bool) error {
+	defer Phase("submodules")()
+	withSubmodules := []string{}
+	for root, pkgs := range repoPackages(packages) {
+		vcs, err := vcsList.IsRoot(root)
+		if err != nil {
+			return err
+		}
+		if _, isGit := vcs.(git); !isGit {
+			continue
+		}
+		var submodules []Submodule
+		_, err = os.Stat(filepath.Join(root, ".gitmodules"))
+		switch {
+		case os.IsNotExist(err):
+			// no submodules
+		case err != nil:
+			return err
+		case !vendorSubmodules:
+			withSubmodules = append(withSubmodules, root)
+			continue
+		case DryRun:
+			planned("check out git submodules of %s recursively", root)
+		default:
+			submodules, err = git{}.UpdateSubmodules(root)
+			if err != nil {
+				return err
+			}
+		}
+		for _, pkg := range pkgs {
+			pkg.Submodules = submodules
+		}
+	}
+	if len(withSubmodules) > 0 {
+		sort.Strings(withSubmodules)
+		return fmt.Errorf("following repositories have git submodules, which would be missing from %s/: %s\n"+
+			"Try running with flag --submodules to vendor contents of the submodules as plain files.",
+			VendorPath, strings.Join(withSubmodules, " "))
+	}
+	return nil
+}
+
+// UpdateSubmodules checks out all submodules of repository root recursively,
+// at revisions recorded in the repository, and returns them.
+func (git) UpdateSubmodules(root string) ([]Submodule, error) {
+	cmd := Command("git", "submodule", "update", "--init", "--recursive").LogAlways().Mutating()
+	cmd.Cmd.Dir = root
+	err := cmd.DiscardOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd = Command("git", "submodule", "status", "--recursive")
+	cmd.Cmd.Dir = root
+	lines, err := cmd.OutputLines()
+	if err != nil {
+		return nil, err
+	}
+	// Example git output (see "git help submodule" -> "status" for details).
+	// First char is a status flag: ' ', '-' (not initialized), '+' (checked
+	// out revision differs from recorded), or 'U' (merge conflicts).
+	//
+	//	 3e4c1f5e1a2b... third_party/foo (v1.0.2)
+	//	-9a0b8c7d6e5f... third_party/bar
+	submodules := []Submodule{}
+	for _, line := range lines {
+		// NOTE: leading space of first line is trimmed by OutputLines.
+		line = strings.TrimLeft(line, " ")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("unexpected format of git output in %s: %q", root, line)
+		}
+		if strings.IndexByte("-+U", line[0]) != -1 {
+			return nil, fmt.Errorf("submodule %s in %s not checked out at recorded revision: %q", fields[1], root, line)
+		}
+		submodules = append(submodules, Submodule{
+			Path:     filepath.ToSlash(fields[1]),
+			Revision: fields[0],
+		})
+	}
+	return submodules, nil
+}
+
+// StagedGitlinks returns paths of all "gitlinks" (i.e. submodule entries) in
+// git's "staging area" under subpath.
+func (g git) StagedGitlinks(root, subpath string) ([]string, error) {
+	lines, err := g.command(root, "ls-files", "--stage", "--", subpath).
+		OutputLines()
+	if err != nil {
+		return nil, err
+	}
+	// Example git output:
+	//
+	//	100644 5716ca5987cbf97d6bb54920bea6adde242d87e6 0	_vendor/.gitignore
+	//	160000 9a0b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b 0	_vendor/src/github.com/foo/bar
+	gitlinks := []string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "160000 ") {
+			gitlinks = append(gitlinks, strings.SplitN(line, "\t", 2)[1])
+		}
+	}
+	return gitlinks, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracePath is the file to which the trace is written on Exit, if not empty
+// (flag --trace).
+var TracePath = ""
+
+// TracedCmd describes a single run of an external command.
+type TracedCmd struct {
+	Phase string   `json:"phase"`
+	Args  []string `json:"args"`
+	Dir   string   `json:"dir,omitempty"`
+	// Env lists environment variables changed vs. vendo's own environment, in
+	// format "KEY=value" (or "KEY=" if removed).
+	Env         []string  `json:"env,omitempty"`
+	Start       time.Time `json:"start"`
+	DurationMs  float64   `json:"durationMs"`
+	ExitStatus  int       `json:"exitStatus"`
+	Error       string    `json:"error,omitempty"`
+	OutputBytes int       `json:"outputBytes"`
+}
+
+// TracedPhase sums up time spent in a phase of vendo's work, e.g. "recreate/crawl".
+type TracedPhase struct {
+	Name       string  `json:"name"`
+	DurationMs float64 `json:"durationMs"`
+	Commands   int     `json:"commands"`
+	CommandsMs float64 `json:"commandsMs"`
+}
+
+type Trace struct {
+	Args       []string       `json:"args"`
+	Start      time.Time      `json:"start"`
+	DurationMs float64        `json:"durationMs"`
+	Phases     []*TracedPhase `json:"phases"`
+	Commands   []*TracedCmd   `json:"commands"`
+}
+
+// tracer records all Cmds run by vendo, and phases started with Phase.
+var tracer = struct {
+	sync.Mutex
+	Trace
+	phases map[string]*TracedPhase
+	// current is the stack of names of currently active phases.
+	current []string
+}{
+	Trace:  Trace{Args: os.Args, Start: time.Now()},
+	phases: map[string]*TracedPhase{},
+}
+
+// Phase marks start of a named phase of work, and returns a function marking
+// its end. Phases can be nested; a nested phase is named "outer/inner", and
+// commands are counted in the innermost phase. Typical usage:
+//
+//	defer Phase("crawl")()
+func Phase(name string) (end func()) {
+	tracer.Lock()
+	defer tracer.Unlock()
+	tracer.current = append(tracer.current, name)
+	full := strings.Join(tracer.current, "/")
+	phase := tracer.phases[full]
+	if phase == nil {
+		phase = &TracedPhase{Name: full}
+		tracer.phases[full] = phase
+		tracer.Phases = append(tracer.Phases, phase)
+	}
+	start := time.Now()
+	return func() {
+		tracer.Lock()
+		defer tracer.Unlock()
+		phase.DurationMs += milliseconds(time.Since(start))
+		// NOTE: phases are expected to end in reverse order of starting.
+		for i := len(tracer.current) - 1; i >= 0; i-- {
+			if tracer.current[i] == name {
+				tracer.current = tracer.current[:i]
+				break
+			}
+		}
+	}
+}
+
+// traceCmd records a finished run of cmd.
+func traceCmd(cmd *Cmd, start time.Time, outputBytes int, err error) {
+	duration := time.Since(start)
+	traced := &TracedCmd{
+		Args:        cmd.Cmd.Args,
+		Dir:         cmd.Cmd.Dir,
+		Env:         envDiff(cmd.Cmd.Env),
+		Start:       start,
+		DurationMs:  milliseconds(duration),
+		ExitStatus:  -1,
+		OutputBytes: outputBytes,
+	}
+	if cmd.Cmd.ProcessState != nil {
+		traced.ExitStatus = cmd.Cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		traced.Error = err.Error()
+	}
+
+	tracer.Lock()
+	defer tracer.Unlock()
+	traced.Phase = strings.Join(tracer.current, "/")
+	tracer.Commands = append(tracer.Commands, traced)
+	if phase := tracer.phases[traced.Phase]; phase != nil {
+		phase.Commands++
+		phase.CommandsMs += traced.DurationMs
+	}
+}
+
+// writeTrace writes the trace to TracePath as JSON, and prints the summary of
+// phases to stderr. It does nothing if TracePath is empty.
+func writeTrace() {
+	if TracePath == "" {
+		return
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	tracer.DurationMs = milliseconds(time.Since(tracer.Start))
+	if tracer.Phases == nil {
+		tracer.Phases = []*TracedPhase{}
+	}
+	if tracer.Commands == nil {
+		tracer.Commands = []*TracedCmd{}
+	}
+	buf, err := json.MarshalIndent(tracer.Trace, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(TracePath, append(buf, '\n'), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vendo: cannot write trace: %s\n", err)
+	}
+	tracer.Trace.writeSummary(os.Stderr)
+}
+
+// writeSummary prints time spent in each phase, and in commands run directly
+// in it (not in nested phases), in order of starting. Commands run outside
+// any phase are summed up as "(no phase)".
+//
+// Example output:
+//
+//	# trace: 41.52s total, 612 commands in 39.80s
+//	#     0.12s  check consistency (commands: 4 in 0.11s)
+//	#    40.95s  check dependencies (commands: 3 in 0.05s)
+//	#    40.90s  check dependencies/crawl (commands: 598 in 38.71s)
+func (t *Trace) writeSummary(w io.Writer) {
+	total := 0.0
+	for _, cmd := range t.Commands {
+		total += cmd.DurationMs
+	}
+	fmt.Fprintf(w, "# trace: %s total, %d commands in %s\n",
+		seconds(t.DurationMs), len(t.Commands), seconds(total))
+
+	phases := append([]*TracedPhase{}, t.Phases...)
+	outside := &TracedPhase{Name: "(no phase)"}
+	for _, cmd := range t.Commands {
+		if cmd.Phase == "" {
+			outside.Commands++
+			outside.CommandsMs += cmd.DurationMs
+		}
+	}
+	if outside.Commands > 0 {
+		phases = append(phases, outside)
+	}
+	for _, phase := range phases {
+		duration := "       -"
+		if phase != outside {
+			duration = fmt.Sprintf("%8s", seconds(phase.DurationMs))
+		}
+		fmt.Fprintf(w, "# %s  %s (commands: %d in %s)\n",
+			duration, phase.Name, phase.Commands, seconds(phase.CommandsMs))
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func seconds(ms float64) string {
+	return fmt.Sprintf("%.2fs", ms/1000)
+}
@@ -20,13 +20,24 @@ func init() {
 		Short: fmt.Sprintf("update a third-party repo in %s/ from the Internet (like `go get -u`)",
 			VendorPath),
 		Example: "  vendo update rsc.io/pdf",
-		Long:    "Update calls `go get -u` on a specified vendored package,\nin order to download its newer version from the Internet.",
+		Long: `Update downloads a newer revision of the repository of a specified vendored
+package, and recreates vendor.json.
+
+The revision is fetched (with git fetch, hg pull or bzr pull) from the origin
+of the repository recorded in vendor.json, or set with flag --origin (e.g. a
+fork, or a local path). New dependencies of the updated packages are cloned
+from GOPATH, like in 'vendo recreate --clone', or downloaded with 'go get -d'
+if missing there. If the origin is not known, the repository is downloaded
+with 'go get' instead.`,
 	}
 	var (
 		force         = cmd.Flags().Bool("f", false, "force package update even if it's not clean")
 		deletePatch   = cmd.Flags().Bool("delete-patch", false, "ignore local patches in the updated repository")
 		platformsList = cmd.Flags().String("platforms", "", "format: OS_ARCH,OS_ARCH2[,...]")
+		origin        = cmd.Flags().String("origin", "", "URL or path of the repository to fetch from, instead of the one in "+JsonPath)
+		revision      = cmd.Flags().String("revision", "", "branch, tag or revision to update to (default: origin's default head)")
 	)
+	cmd.Flags().BoolVar(&DryRun, "dry-run", false, dryRunUsage)
 	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
 		if len(args) != 1 {
 			// TODO(mateuszc): subcmd usage
@@ -39,14 +50,14 @@ func init() {
 			return err
 		}
 
-		return Update(updatedImp, platforms, *force, *deletePatch)
+		return Update(updatedImp, platforms, *force, *deletePatch, *origin, *revision)
 	})
 	cmds.AddCommand(cmd)
 }
 
-func Update(updatedImp string, platforms []Platform, force, deletePatch bool) error {
+func Update(updatedImp string, platforms []Platform, force, deletePatch bool, origin, revision string) error {
 	// Make sure we're in project's root dir (with .git/, vendor.json, and _vendor/)
-	exist := Exist{}.Dir(".git").File(JsonPath).Dir(VendorPath)
+	exist := Exist{}.GitDir(".git").File(JsonPath).Dir(VendorPath)
 	if exist.Err != nil {
 		return exist.Err
 	}
@@ -82,23 +93,69 @@ func Update(updatedImp string, platforms []Platform, force, deletePatch bool) er
 	// This is required for `git status` calls and the final Recreate() call.
 	// (use-cases.md 5.4.1.1)
 	fmt.Fprintf(os.Stderr, "# rm -f %s\n", GitignorePath)
-	err = os.Remove(GitignorePath)
-	if err != nil && !os.IsNotExist(err) {
+	err = removeFile(GitignorePath)
+	if err != nil {
 		return err
 	}
 
 	if !force {
-		err := verifyCleanInProject(updatedPkg)
+		err := verifyCleanInProject(pkgs, updatedPkg)
 		if err != nil {
 			return err
 		}
 	}
 
+	// Find where to download the new revision from. If the origin is not known (e.g. vendor.json was created by an older version
+	// of the tool, and the repository has no .git/.hg/.bzr subdir), fall back to `go get`.
+	// (use-cases.md 5.4.2.1)
+	vcs, origin, err := findOrigin(updatedPkg, origin)
+	if err != nil {
+		return err
+	}
+	clone := false
+	if vcs == nil {
+		if revision != "" {
+			return fmt.Errorf("cannot update %s to revision %q: origin not known (set flag --origin)", updatedPkg.RepositoryRoot, revision)
+		}
+		err = updateViaGoGet(pkgs, updatedPkg, platforms, deletePatch)
+	} else {
+		err = updateViaFetch(pkgs, updatedPkg, vcs, origin, revision, deletePatch)
+		if err == nil {
+			err = getMissingDependencies(platforms, pkgs.NoTestDeps)
+		}
+		// New dependencies of the updated repository found in GOPATH must be cloned from there, like in
+		// `vendo recreate --clone`.
+		clone = true
+	}
+	if err != nil {
+		return err
+	}
+
+	// `vendo-recreate`;
+	//  * *[Note]* Value of argument `-platforms` for *vendo-add* should be copied verbatim from argument `-platforms` of
+	//    *vendo-update*, or read from *vendor.json* custom global field "platforms" otherwise;
+	//  * *[Note]* This will update revision-id & revision-date for $PKG in *vendor.json*;
+	//  * *[Note]* This will also add any new pkgs downloaded because they're dependencies of $PKG;
+	//  * *[Note]* Dependencies of tests are skipped if *vendor.json* has "noTestDeps" set;
+	//  * *[Note]* Repositories are pruned again if *vendor.json* has "prune" set;
+	//  * *[Note]* Git submodules are vendored as plain files if *vendor.json* has "vendorSubmodules" set;
+	// (use-cases.md 5.4.1.9)
+	err = Recreate(platforms, clone, pkgs.NoTestDeps, pkgs.Prune, pkgs.VendorSubmodules)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// updateViaGoGet replaces the updated repository with the one downloaded by
+// `go get`.
+func updateViaGoGet(pkgs *VendorFile, updatedPkg *VendorPackage, platforms []Platform, deletePatch bool) error {
 	// Delete the updated repository from disk, but keep it in git's memory.
 	// `rm -rf _vendor/$PKG_REPO_ROOT`
 	// (use-cases.md 5.4.1.3)
 	fmt.Fprintf(os.Stderr, "# rm -rf %s\n", updatedPkg.RepositoryRoot)
-	err = os.RemoveAll(updatedPkg.RepositoryRoot)
+	err := removeAll(updatedPkg.RepositoryRoot)
 	if err != nil {
 		return err
 	}
@@ -108,35 +165,33 @@ func Update(updatedImp string, platforms []Platform, force, deletePatch bool) er
 		return err
 	}
 
-	// Update the requested repository from the Internet, via `go get`.
-	// FIXME(mateuszc): probably must run `go get -u` for each platform, to make sure all deps are fetched
+	// Update the requested repository from the Internet, via `go get`, separately for each platform to make sure all
+	// dependencies are fetched.
 	// NOTE(mateuszc): `go get -d` because some pkgs may be single-platform-only, we don't want to build them on bad platform
 	// (use-cases.md 5.4.1.4)
-	err = Command("go", "get", "-d", "--", updatedPkg.Canonical).
-		Setenv(
-		"GOPATH=" + vendorAbsPath).
-		LogAlways().
-		DiscardOutput()
-	if err != nil {
-		return err
-	}
-
-	if !deletePatch {
-		err := verifyNotPatchedLocally(updatedPkg)
+	for _, platform := range platforms {
+		err = Command("go", "get", "-d", "--", updatedPkg.Canonical).
+			Setenv(
+				"GOPATH="+vendorAbsPath,
+				"GOOS="+platform.Os,
+				"GOARCH="+platform.Arch).
+			LogAlways().
+			Mutating().
+			DiscardOutput()
 		if err != nil {
 			return err
 		}
 	}
 
-	// `vendo-recreate`;
-	//  * *[Note]* Value of argument `-platforms` for *vendo-add* should be copied verbatim from argument `-platforms` of
-	//    *vendo-update*, or read from *vendor.json* custom global field "platforms" otherwise;
-	//  * *[Note]* This will update revision-id & revision-date for $PKG in *vendor.json*;
-	//  * *[Note]* This will also add any new pkgs downloaded because they're dependencies of $PKG;
-	// (use-cases.md 5.4.1.9)
-	err = Recreate(platforms, false)
-	if err != nil {
-		return err
+	if DryRun {
+		// Nothing was downloaded, so the new revision is not known, and there's nothing to verify.
+		planned("verify that %s is not patched locally, by checking out revision %s", updatedPkg.RepositoryRoot, updatedPkg.Revision)
+		planned("recreate %s, with %s at revision downloaded by `go get`", JsonPath, updatedPkg.RepositoryRoot)
+	} else if !deletePatch {
+		err := verifyNotPatchedLocally(pkgs, updatedPkg)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -148,9 +203,9 @@ func Update(updatedImp string, platforms []Platform, force, deletePatch bool) er
 //   "subrepos" are consistent. Similarly, if they are "modified" from perspective of the main repo, this means some work was maybe
 //   done in the main repo, and this is important to warn about.
 // (use-cases.md 5.4.1.2)
-func verifyCleanInProject(updatedPkg *VendorPackage) error {
+func verifyCleanInProject(pkgs *VendorFile, updatedPkg *VendorPackage) error {
 	fmt.Fprintf(os.Stderr, "# git status %s\n", updatedPkg.RepositoryRoot)
-	clean, err := git{}.IsClean(".", updatedPkg.RepositoryRoot)
+	clean, err := isCleanInProject(pkgs, updatedPkg)
 	if err != nil {
 		return err
 	}
@@ -161,10 +216,10 @@ func verifyCleanInProject(updatedPkg *VendorPackage) error {
 	return nil
 }
 
-func verifyNotPatchedLocally(updatedPkg *VendorPackage) error {
+func verifyNotPatchedLocally(pkgs *VendorFile, updatedPkg *VendorPackage) error {
 	// Find repository root
 	impDir := filepath.Join(VendorPath, "src", updatedPkg.Canonical)
-	repoRoot, vcs, err := vcsList.FindRoot(impDir)
+	repoRoot, vcs, err := vcsList.FindVendoredRoot(impDir)
 	if err != nil {
 		return err
 	}
@@ -202,9 +257,11 @@ func verifyNotPatchedLocally(updatedPkg *VendorPackage) error {
 	//   status` is clean. If `git status` *does* show diff, this means our repo remembers something different (a "patch") than what we
 	//   recreated based on revision-id listed in *vendor.json*. So, we must quit, and print an error message: "vendored pkg is patched
 	//   locally; please merge manually".
+	//  * *[Note]* If the repository is pruned, the files not added to main repo are expected to show up as untracked, unless pruning
+	//    would keep them (then they were deleted by a local patch).
 	// (use-cases.md 5.4.1.7)
 	fmt.Fprintf(os.Stderr, "# git status %s\n", updatedPkg.RepositoryRoot)
-	clean, err := git{}.IsClean(".", updatedPkg.RepositoryRoot)
+	clean, err := isCleanInProject(pkgs, updatedPkg)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// findOrigin returns the version control system and origin of the repository
+// of updatedPkg. The origin is, in order of preference: the one set by user
+// (flag --origin), the "source" override or the origin recorded in
+// vendor.json, or the one configured in the repository's .git/.hg/.bzr subdir. If the origin is not known, nil Vcs
+// is returned.
+// (use-cases.md 5.4.2.1)
+func findOrigin(updatedPkg *VendorPackage, origin string) (Vcs, string, error) {
+	root := updatedPkg.RepositoryRoot
+	diskVcs, err := vcsList.IsRoot(root)
+	if err != nil {
+		return nil, "", err
+	}
+	vcs := diskVcs
+	if updatedPkg.Vcs != "" {
+		vcs = vcsList.ByName(updatedPkg.Vcs)
+		if vcs == nil {
+			return nil, "", fmt.Errorf(`unknown "vcs": %q for import path %s in %s`,
+				updatedPkg.Vcs, updatedPkg.Canonical, JsonPath)
+		}
+	}
+	if origin == "" {
+		origin = updatedPkg.Source
+	}
+	if origin == "" {
+		origin = updatedPkg.Origin
+	}
+	if origin == "" && diskVcs != nil {
+		origin, err = diskVcs.Origin(root)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if origin == "" {
+		return nil, "", nil
+	}
+	if vcs == nil {
+		// Origin set by user for a repository vendored by an older version of the tool. Only a local origin can be checked.
+		vcs, err = vcsList.IsRoot(localOriginPath(origin))
+		if err != nil {
+			return nil, "", err
+		}
+		if vcs == nil {
+			return nil, "", fmt.Errorf(`cannot detect version control system of %s (missing "vcs" for import path %s in %s)`,
+				origin, updatedPkg.Canonical, JsonPath)
+		}
+	}
+	return vcs, origin, nil
+}
+
+// localOriginPath converts a file:// URL origin to a local path. Other
+// origins are returned unchanged.
+func localOriginPath(origin string) string {
+	if !strings.HasPrefix(origin, "file://") {
+		return origin
+	}
+	path := strings.TrimPrefix(origin, "file://")
+	if runtime.GOOS == "windows" {
+		// file:///C:/foo
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
+
+// updateViaFetch replaces the updated repository with a snapshot of revision
+// (or of origin's default head, if empty) fetched from origin into a scratch
+// clone. Unless deletePatch is set, the scratch clone is first used to verify
+// that the repository was not patched locally since vendoring.
+// (use-cases.md 5.4.2)
+func updateViaFetch(pkgs *VendorFile, updatedPkg *VendorPackage, vcs Vcs, origin, revision string, deletePatch bool) error {
+	defer Phase("fetch")()
+	root := updatedPkg.RepositoryRoot
+	if !deletePatch && updatedPkg.Revision == "" {
+		return fmt.Errorf(`empty "revision" for %s in %s`, updatedPkg.Canonical, JsonPath)
+	}
+	if DryRun {
+		// Nothing is fetched, so the new revision is not known.
+		planned("fetch %s repository %s (or its mirror) into a scratch directory", vcsName(vcs), origin)
+		if !deletePatch {
+			planned("verify that %s is not patched locally, by replacing it with revision %s", root, updatedPkg.Revision)
+		}
+		if revision == "" {
+			revision = "default head of origin"
+		}
+		planned("replace %s with revision: %s", root, revision)
+		planned("recreate %s, with %s at the fetched revision", JsonPath, root)
+		return nil
+	}
+
+	scratch, err := ioutil.TempDir("", "vendo-update-")
+	if err != nil {
+		return err
+	}
+	removeCleanup := AddCleanup(func() { os.RemoveAll(scratch) })
+	defer func() {
+		removeCleanup()
+		os.RemoveAll(scratch)
+	}()
+	repo := filepath.Join(scratch, "repo")
+	err = os.Mkdir(repo, 0755)
+	if err != nil {
+		return err
+	}
+
+	// If there's a mirror of origin (see 'vendo mirror'), refresh it and fetch from it instead, so that update works offline.
+	// (use-cases.md 5.4.2.2)
+	fetchFrom := origin
+	mirror, err := findMirror(vcs, origin)
+	if err != nil {
+		return err
+	}
+	if mirror != nil && mirror.Exists() {
+		err = mirror.Sync()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "# cannot refresh mirror of %s, using it as is: %s\n", origin, err)
+		}
+		fetchFrom = mirror.Path
+	}
+
+	// Clone the vendored repository if it has .git/.hg/.bzr subdir, so that only new revisions are downloaded.
+	from := fetchFrom
+	if diskVcs, err := vcsList.IsRoot(root); err != nil {
+		return err
+	} else if diskVcs != nil && vcsName(diskVcs) == vcsName(vcs) {
+		from, err = filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "# %s clone %s %s\n", vcsName(vcs), from, repo)
+	err = vcs.Clone(from, repo)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "# %s fetch %s\n", vcsName(vcs), fetchFrom)
+	target, err := vcs.Fetch(repo, fetchFrom, revision)
+	if err != nil {
+		return err
+	}
+	if fetchFrom != origin {
+		// Record the real origin in vendor.json, not the mirror.
+		err = vcs.SetOrigin(repo, origin)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Verify that the updated repository isn't patched locally after vendoring: after replacing it with revision listed in
+	// vendor.json, `git status` in the main repo should be clean.
+	// (use-cases.md 5.4.2.3)
+	if !deletePatch {
+		fmt.Fprintf(os.Stderr, "# cd %s ; vcs checkout %s\n", repo, updatedPkg.Revision)
+		err = vcs.Checkout(repo, updatedPkg.Revision)
+		if err != nil {
+			return fmt.Errorf("cannot find revision %s of %s in %s: %s", updatedPkg.Revision, root, origin, err)
+		}
+		err = replaceSnapshot(repo, root)
+		if err != nil {
+			return err
+		}
+		err = verifyCleanInProject(pkgs, updatedPkg)
+		if err != nil {
+			return err
+		}
+	}
+
+	// (use-cases.md 5.4.2.4)
+	fmt.Fprintf(os.Stderr, "# cd %s ; vcs checkout %s\n", repo, target)
+	err = vcs.Checkout(repo, target)
+	if err != nil {
+		return err
+	}
+	return replaceSnapshot(repo, root)
+}
+
+// getMissingDependencies downloads dependencies of the project which are
+// missing both in _vendor/ and GOPATH (e.g. new dependencies of a repository
+// updated via fetch) with `go get -d`, separately for each platform, like in
+// updateViaGoGet.
+// (use-cases.md 5.4.2.5)
+func getMissingDependencies(platforms []Platform, noTestDeps bool) error {
+	vendorAbsPath, err := getVendorAbsPath()
+	if err != nil {
+		return err
+	}
+	gopath := vendorAbsPath + string(filepath.ListSeparator) + os.Getenv("GOPATH")
+	deps, err := crawlDependencies(gopath, platforms)
+	if err != nil {
+		return err
+	}
+	imports, _ := deps.Imports(noTestDeps)
+	missing := deps.FindMissing(imports)
+	if len(missing) == 0 {
+		return nil
+	}
+	for _, platform := range platforms {
+		err = Command("go", append([]string{"get", "-d", "--"}, missing...)...).
+			Setenv(
+				"GOPATH="+vendorAbsPath,
+				"GOOS="+platform.Os,
+				"GOARCH="+platform.Arch).
+			LogAlways().
+			Mutating().
+			DiscardOutput()
+		if err != nil {
+			return fmt.Errorf("cannot download new dependencies %s: %s", missing, err)
+		}
+	}
+	return nil
+}
+
+// replaceSnapshot replaces contents of directory to with a copy of from,
+// including its .git/.hg/.bzr subdir.
+func replaceSnapshot(from, to string) error {
+	fmt.Fprintf(os.Stderr, "# rm -rf %s ; cp -a %s %s\n", to, from, to)
+	err := os.RemoveAll(to)
+	if err != nil {
+		return err
+	}
+	return copyTree(from, to)
+}
+
+// copyTree copies directory from (recursively) to a new directory to,
+// preserving symlinks and permissions.
+func copyTree(from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(to, rel)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, dest)
+		case info.IsDir():
+			return os.MkdirAll(dest, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			return copyFile(path, dest, info.Mode().Perm())
+		}
+		// Skip other special files, e.g. sockets.
+		return nil
+	})
+}
+
+func copyFile(from, to string, perm os.FileMode) error {
+	r, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// testVendoredProject creates a project in a temporary GOPATH, which imports
+// example.com/dep vendored from a clone of upstream at its first commit, and
+// makes it the current directory. The returned function restores the
+// environment and removes all files.
+func testVendoredProject(test *testing.T, origin func(upstream string) string) (upstream string, cleanup func()) {
+	requireVcs(test, "git")
+	gopath, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	env := [][2]string{{"GOPATH", gopath}, {"GO111MODULE", "off"}}
+	cleanup = func() {
+		os.Chdir(cwd)
+		for _, kv := range env {
+			os.Setenv(kv[0], kv[1])
+		}
+		os.RemoveAll(gopath)
+	}
+	for i, kv := range env {
+		env[i][1] = os.Getenv(kv[0])
+		os.Setenv(kv[0], kv[1])
+	}
+	defer func() {
+		if test.Failed() {
+			cleanup()
+		}
+	}()
+
+	upstream = filepath.Join(gopath, "upstream")
+	writeTree(test, upstream, map[string]string{"dep.go": "package dep\n\nfunc Hello() {}\n"})
+	testRun(test, upstream, "git", "-c", "init.defaultBranch=master", "init", "-q")
+	testRun(test, upstream, "git", "add", "-A")
+	testRun(test, upstream, "git", "commit", "-q", "-m", "first")
+
+	project := filepath.Join(gopath, "src", "example.com", "proj")
+	platform := runtime.GOOS + "_" + runtime.GOARCH
+	writeTree(test, project, map[string]string{
+		"main.go":     "package main\n\nimport \"example.com/dep\"\n\nfunc main() { dep.Hello() }\n",
+		"vendor.json": `{"platforms": ["` + platform + `"], "package": []}`,
+	})
+	testRun(test, project, "git", "-c", "init.defaultBranch=master", "init", "-q")
+	testRun(test, project, "git", "clone", "-q", origin(upstream), filepath.Join(VendorPath, "src", "example.com", "dep"))
+	err = os.Chdir(project)
+	if err != nil {
+		test.Fatal(err)
+	}
+	platforms, err := parsePlatforms(platform)
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = Recreate(platforms, false, false, nil, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	testRun(test, project, "git", "add", "-A")
+	testRun(test, project, "git", "commit", "-q", "-m", "vendor")
+	return upstream, cleanup
+}
+
+// Test_Update_fetch updates a repository vendored from a file:// origin to a
+// new upstream commit.
+func Test_Update_fetch(test *testing.T) {
+	fileURL := func(path string) string { return "file://" + filepath.ToSlash(path) }
+	upstream, cleanup := testVendoredProject(test, fileURL)
+	defer cleanup()
+	defer func(dir string) { MirrorDir = dir }(MirrorDir)
+	MirrorDir = filepath.Join(upstream, "..", "mirrors")
+
+	expectUpdated := func(note, content, revision string) {
+		pkgs, err := ReadVendorFile(JsonPath)
+		if err != nil {
+			test.Fatal(err)
+		}
+		pkg := pkgs.ByCanonical()["example.com/dep"]
+		switch {
+		case pkg == nil:
+			test.Fatalf("%s: example.com/dep missing in %s", note, JsonPath)
+		case pkg.Revision != revision:
+			test.Errorf("%s: expected revision %s, got %s", note, revision, pkg.Revision)
+		case pkg.Origin != fileURL(upstream):
+			test.Errorf("%s: expected origin %s, got %s", note, fileURL(upstream), pkg.Origin)
+		case pkg.Vcs != "git" || pkg.RepositoryRoot != "_vendor/src/example.com/dep":
+			test.Errorf("%s: unexpected entry in %s: %+v", note, JsonPath, pkg)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(VendorPath, "src", "example.com", "dep", "dep.go"))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if string(data) != content {
+			test.Errorf("%s: expected dep.go:\n%s\ngot:\n%s", note, content, data)
+		}
+	}
+	commit := func(content string) string {
+		writeTree(test, upstream, map[string]string{"dep.go": content})
+		testRun(test, upstream, "git", "commit", "-q", "-a", "-m", "next")
+		return testRun(test, upstream, "git", "rev-parse", "HEAD")
+	}
+
+	content := "package dep\n\nfunc Hello() {}\n\nfunc Bye() {}\n"
+	revision := commit(content)
+	err := Update("example.com/dep", nil, false, false, "", "")
+	if err != nil {
+		test.Fatal(err)
+	}
+	expectUpdated("recorded origin", content, revision)
+	testRun(test, ".", "git", "add", "-A")
+	testRun(test, ".", "git", "commit", "-q", "-m", "update")
+
+	// Repository vendored by an older version of the tool: no "vcs" in vendor.json, nor .git/ subdir. The file:// origin set
+	// by user is used to detect the version control system.
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for _, pkg := range pkgs.Packages {
+		pkg.Vcs, pkg.Origin = "", ""
+	}
+	err = pkgs.WriteTo(JsonPath)
+	if err == nil {
+		err = os.RemoveAll(filepath.Join(VendorPath, "src", "example.com", "dep", ".git"))
+	}
+	if err != nil {
+		test.Fatal(err)
+	}
+	testRun(test, ".", "git", "commit", "-q", "-a", "-m", "old vendor.json")
+	content = "package dep\n\nfunc Hello() {}\n"
+	revision = commit(content)
+	err = Update("example.com/dep", nil, false, false, fileURL(upstream), "")
+	if err != nil {
+		test.Fatal(err)
+	}
+	expectUpdated("origin set by user", content, revision)
+}
+
+// Test_Update_source switches a vendored repository to a fork set as its
+// "source", and updates it from the fork, keeping the upstream as origin.
+func Test_Update_source(test *testing.T) {
+	upstream, cleanup := testVendoredProject(test, func(upstream string) string { return upstream })
+	defer cleanup()
+	defer func(dir string) { MirrorDir = dir }(MirrorDir)
+	MirrorDir = filepath.Join(upstream, "..", "mirrors")
+	platforms := []Platform{{runtime.GOOS, runtime.GOARCH}}
+	root := filepath.Join(VendorPath, "src", "example.com", "dep")
+
+	fork := filepath.Join(upstream, "..", "fork")
+	testRun(test, ".", "git", "clone", "-q", upstream, fork)
+	content := "package dep\n\nfunc Hello() {}\n\nfunc Forked() {}\n"
+	writeTree(test, fork, map[string]string{"dep.go": content})
+	testRun(test, fork, "git", "commit", "-q", "-a", "-m", "fork")
+	forkRevision := testRun(test, fork, "git", "rev-parse", "HEAD")
+
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	pkg := pkgs.ByCanonical()["example.com/dep"]
+	revision := pkg.Revision
+	pkg.Source = fork
+	err = pkgs.WriteTo(JsonPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	testRun(test, ".", "git", "commit", "-q", "-a", "-m", "source")
+	expect := func(note, revision string) {
+		pkgs, err := ReadVendorFile(JsonPath)
+		if err != nil {
+			test.Fatal(err)
+		}
+		pkg := pkgs.ByCanonical()["example.com/dep"]
+		if pkg.Revision != revision || pkg.Origin != upstream || pkg.Source != fork {
+			test.Errorf("%s: expected revision %s, origin %s, source %s; got %+v", note, revision, upstream, fork, pkg)
+		}
+		origin, err := git{}.Origin(root)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if origin != fork {
+			test.Errorf("%s: expected %s cloned from %s, got %s", note, root, fork, origin)
+		}
+	}
+
+	// Repository cloned from the upstream before "source" was set.
+	err = Recreate(platforms, true, false, nil, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	expect("recreate existing", revision)
+
+	// Missing repository.
+	err = os.RemoveAll(root)
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = Recreate(platforms, true, false, nil, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	expect("recreate missing", revision)
+	if status := testRun(test, ".", "git", "status", "--porcelain"); status != "" {
+		test.Errorf("expected no changes after recreate, got:\n%s", status)
+	}
+
+	err = Update("example.com/dep", nil, false, false, "", "")
+	if err != nil {
+		test.Fatal(err)
+	}
+	expect("update", forkRevision)
+	data, err := ioutil.ReadFile(filepath.Join(root, "dep.go"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(data) != content {
+		test.Errorf("expected dep.go from fork:\n%s\ngot:\n%s", content, data)
+	}
+}
+
+// Test_Update_fetchNewDependency updates a repository to a revision with a new
+// dependency, missing both in _vendor/ and GOPATH, which is downloaded with
+// `go get -d`.
+func Test_Update_fetchNewDependency(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("needs sh")
+	}
+	upstream, cleanup := testVendoredProject(test, func(upstream string) string { return upstream })
+	defer cleanup()
+	defer func(dir string) { MirrorDir = dir }(MirrorDir)
+	MirrorDir = filepath.Join(upstream, "..", "mirrors")
+
+	newdep := filepath.Join(upstream, "..", "newdep")
+	writeTree(test, newdep, map[string]string{"newdep.go": "package newdep\n\nfunc New() {}\n"})
+	testRun(test, newdep, "git", "-c", "init.defaultBranch=master", "init", "-q")
+	testRun(test, newdep, "git", "add", "-A")
+	testRun(test, newdep, "git", "commit", "-q", "-m", "first")
+	writeTree(test, upstream, map[string]string{"dep.go": "package dep\n\nimport \"example.com/newdep\"\n\nfunc Hello() { newdep.New() }\n"})
+	testRun(test, upstream, "git", "commit", "-q", "-a", "-m", "next")
+
+	// Fake `go get -d`, as GOPATH mode `go get` is not supported by recent versions of Go. Other commands run the real go.
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		test.Fatal(err)
+	}
+	bin := filepath.Join(upstream, "..", "bin")
+	log := filepath.Join(bin, "go.log")
+	writeTree(test, bin, map[string]string{"go": fmt.Sprintf(`#!/bin/sh
+[ "$1" = get ] || exec '%s' "$@"
+echo "GOOS=$GOOS $*" >>'%s'
+[ "$*" = "get -d -- example.com/newdep" ] || exit 1
+[ -d "$GOPATH/src/example.com/newdep" ] || exec git clone -q '%s' "$GOPATH/src/example.com/newdep"
+`, goBin, log, newdep)})
+	err = os.Chmod(filepath.Join(bin, "go"), 0755)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(filepath.ListSeparator)+os.Getenv("PATH"))
+
+	err = Update("example.com/dep", nil, false, false, "", "")
+	if err != nil {
+		test.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(log)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if expected := "GOOS=" + runtime.GOOS + " get -d -- example.com/newdep\n"; string(data) != expected {
+		test.Errorf("expected `go` called once:\n%s\ngot:\n%s", expected, data)
+	}
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	pkg := pkgs.ByCanonical()["example.com/newdep"]
+	if pkg == nil || pkg.RepositoryRoot != "_vendor/src/example.com/newdep" || pkg.Origin != newdep {
+		test.Errorf("expected example.com/newdep vendored from %s, got %+v", newdep, pkg)
+	}
+
+	// Dependencies already vendored are not downloaded again.
+	testRun(test, ".", "git", "add", "-A")
+	testRun(test, ".", "git", "commit", "-q", "-m", "update")
+	err = os.Remove(log)
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = Update("example.com/dep", nil, false, false, "", "")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := os.Stat(log); !os.IsNotExist(err) {
+		test.Errorf("expected no `go get` for vendored dependencies, got: %v", err)
+	}
+}
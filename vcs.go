@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -22,15 +25,53 @@ type Vcs interface {
 	Clone(from, to string) error
 	Revision(root string) (string, error)
 	RevisionTime(root string) (string, error)
+	// RevisionSubject returns first line of the description (commit message)
+	// of the currently checked out revision.
+	RevisionSubject(root string) (string, error)
 	// HeadSymbolicRef attempts to retrieve a symbolic name of the currently
 	// checked out revision (e.g. branch or tag name). If not possible, it
-	// returns the same result as Revision.
+	// returns the same result as Revision. The result can be passed to
+	// Checkout, to return to the same branch (or bookmark, tag, etc.).
 	HeadSymbolicRef(root string) (string, error)
+	// Checkout updates working tree to revision, which can be a result of
+	// Revision or HeadSymbolicRef.
 	Checkout(root, revision string) error
 	// IsClean returns true when a repository has no changes and no untracked
 	// files in subpath (relative to repository root).  Ignored files are not
 	// taken into account.
 	IsClean(root, subpath string) (bool, error)
+	// IsCleanPruned is like IsClean, but files missing on disk are not
+	// treated as changes (they're expected in pruned repositories, see
+	// PruneConfig), unless kept returns true for them. Paths passed to kept
+	// are slash-separated, relative to repository root.
+	IsCleanPruned(root, subpath string, kept func(file string) bool) (bool, error)
+	// Origin returns URL (or local path) of the repository from which the
+	// repository at root was cloned, or empty string if it's not known.
+	Origin(root string) (string, error)
+	// SetOrigin changes the repository from which `git fetch`, `hg pull`
+	// or `bzr pull` download new revisions by default.
+	SetOrigin(root, origin string) error
+	// Fetch sets origin (see SetOrigin) and downloads all its revisions. It
+	// returns the revision (as returned by Revision) named rev in origin
+	// (e.g. a branch or tag name), or of origin's default head (the one
+	// checked out by clone) if rev is empty.
+	Fetch(root, origin, rev string) (string, error)
+}
+
+// vcsName returns short name of vcs, as used in vendor.json: "git", "hg"
+// or "bzr".
+func vcsName(vcs Vcs) string {
+	return strings.TrimPrefix(vcs.Dir(), ".")
+}
+
+// ByName returns the Vcs with specified vcsName, or nil if not found.
+func (l VcsList) ByName(name string) Vcs {
+	for _, vcs := range l {
+		if vcsName(vcs) == name {
+			return vcs
+		}
+	}
+	return nil
 }
 
 type git struct{}
@@ -41,8 +82,90 @@ func (git) command(root string, args ...string) *Cmd {
 func (git) Dir() string {
 	return ".git"
 }
+
+// metadataDirs returns git metadata dir of repository at root, and the
+// "common" dir with objects and refs (see `git help gitrepository-layout`).
+// Usually both are root/.git, but in worktrees created with `git worktree add`
+// and in checkouts of submodules, root/.git is a file with "gitdir: PATH" line
+// pointing to the metadata dir; the metadata dir of a worktree has a
+// "commondir" file pointing to the common dir.
+func (git) metadataDirs(root string) (gitDir, commonDir string, err error) {
+	gitDir = filepath.Join(root, ".git")
+	stat, err := os.Stat(gitDir)
+	if err != nil {
+		return "", "", err
+	}
+	if !stat.IsDir() {
+		gitDir, err = readPathFile(gitDir, "gitdir: ")
+		if err != nil {
+			return "", "", err
+		}
+	}
+	commonDir, err = readPathFile(filepath.Join(gitDir, "commondir"), "")
+	switch {
+	case os.IsNotExist(err):
+		commonDir = gitDir
+	case err != nil:
+		return "", "", err
+	}
+	for _, dir := range []string{gitDir, commonDir} {
+		stat, err := os.Stat(dir)
+		if err != nil {
+			return "", "", err
+		}
+		if !stat.IsDir() {
+			return "", "", fmt.Errorf("not a git directory: %s", dir)
+		}
+	}
+	return gitDir, commonDir, nil
+}
+
+// readPathFile reads a path from the first line of file, after prefix. A
+// relative path is relative to the file's directory.
+func readPathFile(file, prefix string) (string, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimRight(strings.SplitN(string(buf), "\n", 2)[0], "\r")
+	if !strings.HasPrefix(line, prefix) || len(line) == len(prefix) {
+		return "", fmt.Errorf("unexpected format of %s: %q", file, line)
+	}
+	path := filepath.FromSlash(strings.TrimPrefix(line, prefix))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(file), path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// isSubmoduleCheckout returns true if repository at root is a checkout of a
+// submodule of the repository containing root, i.e. its metadata dir is in
+// "modules/" of the parent's metadata dir.
+func (g git) isSubmoduleCheckout(root string) (bool, error) {
+	gitDir, _, err := g.metadataDirs(root)
+	if err != nil {
+		return false, err
+	}
+	parent, vcs, err := vcsList.FindRoot(filepath.Dir(root))
+	if err != nil || vcs == nil || vcs.Dir() != g.Dir() {
+		return false, err
+	}
+	parentDir, _, err := g.metadataDirs(parent)
+	if err != nil {
+		return false, err
+	}
+	absGitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return false, err
+	}
+	absModules, err := filepath.Abs(filepath.Join(parentDir, "modules"))
+	if err != nil {
+		return false, err
+	}
+	return isSubdir(filepath.ToSlash(absGitDir), filepath.ToSlash(absModules)), nil
+}
 func (git) Clone(from, to string) error {
-	return Command("git", "clone", "--", from, to).DiscardOutput()
+	return Command("git", "clone", "--", from, to).Mutating().DiscardOutput()
 }
 func (g git) Revision(root string) (string, error) {
 	return g.command(root, "rev-parse", "HEAD").OutputOneLine()
@@ -53,18 +176,27 @@ func (git) RevisionTime(root string) (string, error) {
 	return vcsRevisionTime("Mon, 2 Jan 2006 15:04:05 -0700",
 		"git", "--git-dir", filepath.Join(root, ".git"), "log", "-1", "--pretty=format:%aD")
 }
+func (g git) RevisionSubject(root string) (string, error) {
+	return firstLine(g.command(root, "log", "-1", "--pretty=format:%s"))
+}
 func (g git) HeadSymbolicRef(root string) (string, error) {
 	line, err := g.command(root, "symbolic-ref", "-q", "--short", "HEAD").
 		LogNever().
 		OutputOneLine()
 	if err == nil {
 		return line, nil
-	} else {
-		return g.command(root, "rev-parse", "HEAD").OutputOneLine()
 	}
+	// Detached HEAD; e.g. `go get` checks out tag "go1" if present.
+	line, err = g.command(root, "describe", "--tags", "--exact-match", "HEAD").
+		LogNever().
+		OutputOneLine()
+	if err == nil {
+		return line, nil
+	}
+	return g.command(root, "rev-parse", "HEAD").OutputOneLine()
 }
 func (g git) Checkout(root, revision string) error {
-	return g.command(root, "--work-tree", root, "checkout", revision).DiscardOutput()
+	return g.command(root, "--work-tree", root, "checkout", revision).Mutating().DiscardOutput()
 }
 func (g git) IsClean(root, subpath string) (bool, error) {
 	// TODO(mateuszc): what if filepath.IsAbs(subpath)==true?
@@ -75,6 +207,74 @@ func (g git) IsClean(root, subpath string) (bool, error) {
 	}
 	return len(lines) == 0, nil
 }
+func (g git) IsCleanPruned(root, subpath string, kept func(file string) bool) (bool, error) {
+	// NOTE: with -z, paths are not quoted, and are relative to repository root. Output format: "XY PATH" entries, with additional
+	// "ORIG_PATH" entry after renames and copies.
+	out, err := g.command(root, "--work-tree", root, "status", "--porcelain", "-z", subpath).
+		CombinedOutput()
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if entry == "" {
+			continue
+		}
+		// " D" means deleted in work tree, "D " deleted in index.
+		if len(entry) < 4 || strings.TrimSpace(entry[:2]) != "D" || kept(entry[3:]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+func (g git) Origin(root string) (string, error) {
+	// NOTE: `git config --get` fails with exit status 1 if the key is not set.
+	lines, err := g.command(root, "config", "--get", "remote.origin.url").
+		LogNever().
+		OutputLines()
+	if err != nil || len(lines) == 0 {
+		return "", nil
+	}
+	return lines[0], nil
+}
+func (g git) SetOrigin(root, origin string) error {
+	// NOTE: this also creates remote "origin" if it didn't exist, like `git remote add`.
+	err := g.command(root, "config", "remote.origin.url", origin).Mutating().DiscardOutput()
+	if err != nil {
+		return err
+	}
+	return g.command(root, "config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*").Mutating().DiscardOutput()
+}
+func (g git) Fetch(root, origin, rev string) (string, error) {
+	err := g.SetOrigin(root, origin)
+	if err != nil {
+		return "", err
+	}
+	err = g.command(root, "fetch", "--quiet", "--force", "--prune", "--tags", "origin").
+		LogAlways().
+		DiscardOutput()
+	if err != nil {
+		return "", err
+	}
+	names := []string{"refs/remotes/origin/" + rev, rev}
+	if rev == "" {
+		// Find out which branch is checked out by `git clone`.
+		err = g.command(root, "remote", "set-head", "origin", "--auto").DiscardOutput()
+		if err != nil {
+			return "", fmt.Errorf("cannot find default branch of git repository %s (set revision explicitly): %s", origin, err)
+		}
+		names = []string{"refs/remotes/origin/HEAD"}
+	}
+	// NOTE: local branches of the repository may be stale, so branches of origin are preferred.
+	for _, name := range names {
+		line, err := g.command(root, "rev-parse", "--verify", "-q", name+"^{commit}").
+			LogNever().
+			OutputOneLine()
+		if err == nil {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find revision %q in git repository %s", rev, origin)
+}
 
 type mercurial struct{}
 
@@ -82,7 +282,7 @@ func (mercurial) Dir() string {
 	return ".hg"
 }
 func (mercurial) Clone(from, to string) error {
-	return Command("hg", "clone", "--", from, to).DiscardOutput()
+	return Command("hg", "clone", "--", from, to).Mutating().DiscardOutput()
 }
 func (mercurial) Revision(root string) (string, error) {
 	return Command("hg", "-R", root, "parent", "--template", "{node}").OutputOneLine()
@@ -91,22 +291,129 @@ func (mercurial) RevisionTime(root string) (string, error) {
 	return vcsRevisionTime(time.RFC3339,
 		"hg", "-R", root, "parent", "--template", "{date | rfc3339date}")
 }
+func (mercurial) RevisionSubject(root string) (string, error) {
+	return firstLine(Command("hg", "-R", root, "parent", "--template", "{desc|firstline}"))
+}
+
+// HeadSymbolicRef returns, in order of preference: the active bookmark; the
+// named branch, if the working directory is at its tipmost head (where `hg
+// update BRANCH` goes); a tag (other than "tip"); or the revision.
 func (mercurial) HeadSymbolicRef(root string) (string, error) {
-	// FIXME(mateuszc): try to retrieve proper "symbolic-ref"
-	return mercurial{}.Revision(root)
+	out, err := Command("hg", "-R", root, "log", "-r", ".", "--template", "{node}\n{activebookmark}\n{branch}\n{tags}\n").
+		CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Split(string(out), "\n")
+	if len(fields) < 4 {
+		return "", fmt.Errorf("unexpected format of hg output: %q", out)
+	}
+	node, bookmark, branch, tags := fields[0], fields[1], fields[2], strings.Fields(fields[3])
+	if bookmark != "" {
+		return bookmark, nil
+	}
+	branchTip, err := Command("hg", "-R", root, "log", "-r", "max(head() and branch(.))", "--template", "{node}").
+		OutputOneLine()
+	if err != nil {
+		return "", err
+	}
+	if branchTip == node {
+		return branch, nil
+	}
+	for _, tag := range tags {
+		if tag != "tip" {
+			return tag, nil
+		}
+	}
+	return node, nil
 }
 func (mercurial) Checkout(root, revision string) error {
-	// FIXME(mateuszc): make sure if we're ok to use -R or we should use --cwd
-	return Command("hg", "-R", root, "update", revision).DiscardOutput()
+	// NOTE: -R is enough, `hg update` operates on the working directory of the repository. Updating to a bookmark name makes it
+	// active, while updating to any other revision deactivates it.
+	return Command("hg", "-R", root, "update", revision).Mutating().DiscardOutput()
+}
+func (m mercurial) IsClean(root, subpath string) (bool, error) {
+	return m.isClean(root, subpath, "--modified", "--added", "--removed", "--deleted", "--unknown")
 }
-func (mercurial) IsClean(root, subpath string) (bool, error) {
-	lines, err := Command("hg", "-R", root, "status", filepath.Join(root, subpath)).
+func (m mercurial) IsCleanPruned(root, subpath string, kept func(file string) bool) (bool, error) {
+	// All statuses except "deleted" (i.e. missing, shown as "!").
+	clean, err := m.isClean(root, subpath, "--modified", "--added", "--removed", "--unknown")
+	if err != nil || !clean {
+		return clean, err
+	}
+	// NOTE: with --cwd, paths are relative to the repository root; they're separated with NUL bytes by --print0.
+	out, err := Command("hg", "--cwd", root, "status", "--deleted", "--no-status", "--print0", "--", subpath).
+		CombinedOutput()
+	if err != nil {
+		return false, err
+	}
+	for _, file := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if file != "" && kept(filepath.ToSlash(file)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+func (mercurial) isClean(root, subpath string, statuses ...string) (bool, error) {
+	// NOTE: file arguments are relative to the current directory, so with --cwd, subpath is relative to the repository root.
+	args := append([]string{"--cwd", root, "status"}, statuses...)
+	lines, err := Command("hg", append(args, "--", subpath)...).
 		OutputLines()
 	if err != nil {
 		return false, err
 	}
 	return len(lines) == 0, nil
 }
+func (mercurial) Origin(root string) (string, error) {
+	// NOTE: `hg paths NAME` fails with exit status 1 if the path is not set.
+	lines, err := Command("hg", "-R", root, "paths", "default").
+		LogNever().
+		OutputLines()
+	if err != nil || len(lines) == 0 {
+		return "", nil
+	}
+	return lines[0], nil
+}
+
+// SetOrigin sets path "default" in .hg/hgrc of the repository, as hg has no
+// command for changing it.
+func (mercurial) SetOrigin(root, origin string) error {
+	hgrc := filepath.Join(root, ".hg", "hgrc")
+	buf, err := ioutil.ReadFile(hgrc)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeFile(hgrc, []byte(setIniValue(string(buf), "paths", "default", origin)))
+}
+
+// Fetch returns, if rev is empty, the revision checked out by `hg clone`: the
+// "@" bookmark if present, or the tipmost head of the default branch.
+func (m mercurial) Fetch(root, origin, rev string) (string, error) {
+	err := m.SetOrigin(root, origin)
+	if err != nil {
+		return "", err
+	}
+	err = Command("hg", "-R", root, "pull", "--quiet").
+		LogAlways().
+		DiscardOutput()
+	if err != nil {
+		return "", err
+	}
+	revsets := []string{rev}
+	if rev == "" {
+		revsets = []string{"bookmark('re:^@$')", "max(head() and branch(default))"}
+	}
+	for _, revset := range revsets {
+		node, err := firstLine(Command("hg", "-R", root, "log", "-r", revset, "--template", "{node}\n"))
+		if err != nil {
+			return "", err
+		}
+		if node != "" {
+			return node, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find revision %q in hg repository %s", rev, origin)
+}
 
 type bazaar struct{}
 
@@ -115,49 +422,249 @@ func (bazaar) Dir() string {
 }
 func (bazaar) Clone(from, to string) error {
 	// FIXME(mateuszc): verify that 'to' is a dir before removing
-	err := os.Remove(to)
+	err := removeFile(to)
 	if err != nil {
 		return err
 	}
-	return Command("bzr", "clone", "--", from, to).DiscardOutput()
+	return Command("bzr", "clone", "--", from, to).Mutating().DiscardOutput()
 }
-func (bazaar) Revision(root string) (string, error) {
-	return Command("bzr", "version-info", "--custom", "--template", "{revision_id}", root).OutputOneLine()
+
+// Revision returns revision of the working tree. (Note: `bzr version-info`
+// and `bzr log` show the branch tip instead, which differs after `bzr update
+// -r`.)
+func (b bazaar) Revision(root string) (string, error) {
+	_, revid, err := b.revisionInfo(root, "--tree")
+	return revid, err
 }
-func (bazaar) RevisionTime(root string) (string, error) {
-	// Bzr date format seems to use "+0000", not "Z", for GMT, see:
-	// http://doc.bazaar.canonical.com/beta/en/user-guide/version_info.html
-	return vcsRevisionTime("2006-01-02 15:04:05 -0700",
-		"bzr", "version-info", "--custom", "--template", "{date}", root)
+func (b bazaar) RevisionTime(root string) (string, error) {
+	timestamp, _, err := b.logEntry(root)
+	if err != nil {
+		return "", err
+	}
+	t, err := time.Parse("Mon 2006-01-02 15:04:05 -0700", timestamp)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse bzr timestamp %q: %s", timestamp, err)
+	}
+	return t.Format(time.RFC3339), nil
 }
-func (bazaar) HeadSymbolicRef(root string) (string, error) {
-	// FIXME(mateuszc): try to retrieve proper "symbolic-ref"
-	return bazaar{}.Revision(root)
+func (b bazaar) RevisionSubject(root string) (string, error) {
+	_, subject, err := b.logEntry(root)
+	return subject, err
 }
-func (bazaar) Checkout(root, revision string) error {
-	return Command("bzr", "update", "-r", "revid:"+revision, root).DiscardOutput()
+
+// HeadSymbolicRef returns the branch nick if the working tree is at the tip of
+// the branch, or "tag:NAME" for a tag of the revision, or the revision.
+func (b bazaar) HeadSymbolicRef(root string) (string, error) {
+	revid, err := b.Revision(root)
+	if err != nil {
+		return "", err
+	}
+	_, tipRevid, err := b.revisionInfo(root, "-r", "last:1")
+	if err != nil {
+		return "", err
+	}
+	if revid == tipRevid {
+		return Command("bzr", "nick", "-d", root).OutputOneLine()
+	}
+	// Output format: "NAME REVNO" lines.
+	line, err := firstLine(Command("bzr", "tags", "-d", root, "-r", "revid:"+revid))
+	if err != nil {
+		return "", err
+	}
+	if fields := strings.Fields(line); len(fields) > 0 {
+		return "tag:" + fields[0], nil
+	}
+	return revid, nil
+}
+func (b bazaar) Checkout(root, revision string) error {
+	nick, err := Command("bzr", "nick", "-d", root).OutputOneLine()
+	if err != nil {
+		return err
+	}
+	switch {
+	case revision == nick:
+		// Tip of the branch.
+		return Command("bzr", "update", root).Mutating().DiscardOutput()
+	case strings.HasPrefix(revision, "tag:"):
+		return Command("bzr", "update", "-r", revision, root).Mutating().DiscardOutput()
+	default:
+		return Command("bzr", "update", "-r", "revid:"+revision, root).Mutating().DiscardOutput()
+	}
+}
+func (b bazaar) IsClean(root, subpath string) (bool, error) {
+	return b.isClean(root, subpath, nil)
 }
-func (bazaar) IsClean(root, subpath string) (bool, error) {
-	// TODO(mateuszc): test this
-	path := filepath.Join(root, subpath)
-	cmds := []*Cmd{
-		Command("bzr", "modified"),
-		Command("bzr", "deleted"),
-		Command("bzr", "added"),
-		Command("bzr", "ls", "--unknown"),
+func (b bazaar) IsCleanPruned(root, subpath string, kept func(file string) bool) (bool, error) {
+	return b.isClean(root, subpath, kept)
+}
+
+// isClean checks `bzr status` of subpath. If kept is not nil, missing files
+// are treated as changes only if kept returns true for them.
+func (bazaar) isClean(root, subpath string, kept func(file string) bool) (bool, error) {
+	// NOTE: `bzr modified -d PATH` etc. operate on the whole working tree containing PATH, so we use `bzr status` limited to the
+	// subpath instead. Output format (see `bzr help status-flags`): 3 status columns, a space, and a path, e.g.:
+	//	"+N  added.go"
+	//	" M  modified.go"
+	//	" D  missing.go"
+	//	"?   unknown.go"
+	// Any other lines (e.g. "working tree is out of date", printed to stderr after `bzr update -r`) are skipped. Note: we don't use
+	// OutputLines, as it would trim the leading space.
+	out, err := Command("bzr", "status", "--short", "--", filepath.Join(root, subpath)).
+		CombinedOutput()
+	if err != nil {
+		return false, err
 	}
-	for _, cmd := range cmds {
-		cmd.Append("-d", path)
-		lines, err := cmd.OutputLines()
-		if err != nil {
-			return false, err
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 5 || line[3] != ' ' || !strings.ContainsRune("+-R?XCP ", rune(line[0])) ||
+			!strings.ContainsRune("NDKM ", rune(line[1])) || !strings.ContainsRune("* ", rune(line[2])) {
+			continue
 		}
-		if len(lines) > 0 {
-			return false, nil
+		// NOTE: paths are relative to the branch root; directories have a trailing "/", and their missing files are listed
+		// separately.
+		if kept != nil && line[:3] == " D " && (strings.HasSuffix(line, "/") || !kept(strings.TrimLeft(line[3:], " "))) {
+			continue
 		}
+		return false, nil
 	}
 	return true, nil
 }
+func (bazaar) Origin(root string) (string, error) {
+	// NOTE: `bzr config NAME` fails if the option is not set.
+	lines, err := Command("bzr", "config", "-d", root, "parent_location").
+		LogNever().
+		OutputLines()
+	if err != nil || len(lines) == 0 {
+		return "", nil
+	}
+	return lines[0], nil
+}
+func (bazaar) SetOrigin(root, origin string) error {
+	return Command("bzr", "config", "-d", root, "--scope", "branch", "parent_location="+origin).
+		Mutating().
+		DiscardOutput()
+}
+
+// Fetch makes the branch at root a mirror of origin (with `bzr pull
+// --overwrite`), so rev is any revision specifier valid in origin (e.g.
+// "tag:v1.0", "42" or "revid:ID"). If rev is empty, the tip of origin is
+// returned.
+func (b bazaar) Fetch(root, origin, rev string) (string, error) {
+	err := b.SetOrigin(root, origin)
+	if err != nil {
+		return "", err
+	}
+	err = Command("bzr", "pull", "--quiet", "--overwrite", "-d", root, origin).
+		LogAlways().
+		DiscardOutput()
+	if err != nil {
+		return "", err
+	}
+	if rev == "" {
+		rev = "last:1"
+	}
+	_, revid, err := b.revisionInfo(root, "-r", rev)
+	return revid, err
+}
+
+// revisionInfo returns revision number and id from `bzr revision-info` with
+// args.
+func (bazaar) revisionInfo(root string, args ...string) (revno, revid string, err error) {
+	args = append([]string{"revision-info", "-d", root}, args...)
+	line, err := Command("bzr", args...).OutputOneLine()
+	if err != nil {
+		return "", "", err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("unexpected format of bzr output: %q", line)
+	}
+	return fields[0], fields[1], nil
+}
+
+// logEntry returns timestamp and first line of message of the working tree's
+// revision.
+func (b bazaar) logEntry(root string) (timestamp, subject string, err error) {
+	revid, err := b.Revision(root)
+	if err != nil {
+		return "", "", err
+	}
+	// Output format, e.g.:
+	//	------------------------------------------------------------
+	//	revno: 42
+	//	committer: John Doe <john@example.com>
+	//	branch nick: trunk
+	//	timestamp: Wed 2015-07-01 12:34:56 +0200
+	//	message:
+	//	  Fix parsing of dates
+	out, err := Command("bzr", "log", "--long", "-r", "revid:"+revid, root).
+		CombinedOutput()
+	if err != nil {
+		return "", "", err
+	}
+	lines := strings.Split(string(out), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "timestamp: "):
+			timestamp = strings.TrimPrefix(line, "timestamp: ")
+		case line == "message:" && i+1 < len(lines):
+			subject = strings.TrimSpace(lines[i+1])
+		}
+	}
+	if timestamp == "" {
+		return "", "", fmt.Errorf("cannot find timestamp in bzr log of %s", root)
+	}
+	return timestamp, subject, nil
+}
+
+// setIniValue returns text of an INI-style config file (e.g. .hg/hgrc), with
+// key in section set to value. The key is added if not present, at the end of
+// the first matching section; the section is added at the end if not present.
+func setIniValue(text, section, key, value string) string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+	entry := key + " = " + value + "\n"
+	current, insertAt := "", -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			if current == section && insertAt == -1 {
+				insertAt = i + 1
+			}
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if insertAt != -1 && trimmed != "" && !strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, ";") {
+			insertAt = i + 1
+		}
+		if eq := strings.IndexByte(line, '='); eq != -1 && strings.TrimSpace(line[:eq]) == key {
+			lines[i] = entry
+			return strings.Join(lines, "")
+		}
+	}
+	if insertAt == -1 {
+		return strings.Join(lines, "") + "[" + section + "]\n" + entry
+	}
+	lines = append(lines[:insertAt], append([]string{entry}, lines[insertAt:]...)...)
+	return strings.Join(lines, "")
+}
+
+// firstLine returns first line of cmd's output, or empty string if there's no
+// output.
+func firstLine(cmd *Cmd) (string, error) {
+	lines, err := cmd.OutputLines()
+	if err != nil || len(lines) == 0 {
+		return "", err
+	}
+	return lines[0], nil
+}
 
 func vcsRevisionTime(timeFormat, command string, args ...string) (string, error) {
 	line, err := Command(command, args...).OutputOneLine()
@@ -213,6 +720,33 @@ func (l VcsList) IsRoot(path string) (Vcs, error) {
 			// FIXME(mateuszc): try refactoring to save path (repo root) in the Vcs struct
 			return vcs, nil
 		}
+		// A ".git" file, in worktrees and submodule checkouts.
+		if g, ok := vcs.(git); ok {
+			if _, _, err := g.metadataDirs(path); err == nil {
+				return vcs, nil
+			}
+		}
 	}
 	return nil, nil
 }
+
+// FindVendoredRoot is like FindRoot, but checkouts of git submodules of
+// vendored repositories (see handleSubmodules) are treated as part of the
+// repository containing them.
+func (l VcsList) FindVendoredRoot(path string) (string, Vcs, error) {
+	for {
+		root, vcs, err := l.FindRoot(path)
+		if err != nil || vcs == nil {
+			return root, vcs, err
+		}
+		g, ok := vcs.(git)
+		if !ok {
+			return root, vcs, nil
+		}
+		submodule, err := g.isSubmoduleCheckout(root)
+		if err != nil || !submodule {
+			return root, vcs, err
+		}
+		path = filepath.Dir(root)
+	}
+}
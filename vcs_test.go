@@ -0,0 +1,414 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vcsTestSetup describes how to create a test repository for a Vcs.
+type vcsTestSetup struct {
+	vcs Vcs
+	bin string
+	// env isolates the VCS from user's configuration.
+	env    []string
+	init   []string
+	add    []string
+	commit []string
+	// tag returns command tagging revision.
+	tag func(name, revision string) []string
+	// branchRef returns expected HeadSymbolicRef at tip of the default branch
+	// in a clone at dir.
+	branchRef func(dir string) string
+	tagRef    func(name string) string
+	// bookmark returns command creating a bookmark at revision, if supported.
+	bookmark func(name, revision string) []string
+}
+
+var vcsTestSetups = []vcsTestSetup{
+	{
+		vcs: git{},
+		bin: "git",
+		env: []string{"GIT_CONFIG_NOSYSTEM=1", "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com"},
+		// NOTE: default branch name depends on git version and configuration.
+		init:      []string{"git", "-c", "init.defaultBranch=master", "init", "-q"},
+		add:       []string{"git", "add", "-A"},
+		commit:    []string{"git", "commit", "-q", "-m"},
+		tag:       func(name, revision string) []string { return []string{"git", "tag", name, revision} },
+		branchRef: func(string) string { return "master" },
+		tagRef:    func(name string) string { return name },
+	},
+	{
+		vcs:       mercurial{},
+		bin:       "hg",
+		env:       []string{"HGRCPATH=", "HGPLAIN=1", "HGUSER=test <test@example.com>"},
+		init:      []string{"hg", "init", "-q"},
+		add:       []string{"hg", "addremove", "-q"},
+		commit:    []string{"hg", "commit", "-q", "-m"},
+		tag:       func(name, revision string) []string { return []string{"hg", "tag", "-r", revision, name} },
+		branchRef: func(string) string { return "default" },
+		tagRef:    func(name string) string { return name },
+		bookmark:  func(name, revision string) []string { return []string{"hg", "bookmark", "-r", revision, name} },
+	},
+	{
+		vcs:  bazaar{},
+		bin:  "bzr",
+		env:  []string{"BZR_EMAIL=test <test@example.com>", "BZR_LOG=" + os.DevNull},
+		init: []string{"bzr", "init", "-q"},
+		add:  []string{"bzr", "add", "-q"},
+		// NOTE: bzr's default branch nick is the name of branch's directory.
+		commit: []string{"bzr", "commit", "-q", "-m"},
+		tag: func(name, revision string) []string {
+			return []string{"bzr", "tag", "-q", "-r", "revid:" + revision, name}
+		},
+		branchRef: func(dir string) string { return filepath.Base(dir) },
+		tagRef:    func(name string) string { return "tag:" + name },
+	},
+}
+
+// requireVcs skips the test if bin (e.g. "hg") is not installed. If
+// $VENDO_TEST_ALL_VCS is set (e.g. on CI), the test fails instead, so that
+// support of all VCSes is verified.
+func requireVcs(test *testing.T, bin string) {
+	if _, err := exec.LookPath(bin); err == nil {
+		return
+	}
+	if os.Getenv("VENDO_TEST_ALL_VCS") != "" {
+		test.Fatalf("%s not installed, but required by $VENDO_TEST_ALL_VCS", bin)
+	}
+	test.Skipf("%s not installed (set $VENDO_TEST_ALL_VCS to fail instead)", bin)
+}
+
+// Test_Vcs runs all methods of each Vcs against local repositories. VCSes
+// which are not installed are skipped, unless $VENDO_TEST_ALL_VCS is set.
+func Test_Vcs(test *testing.T) {
+	for _, setup := range vcsTestSetups {
+		setup := setup
+		test.Run(setup.bin, func(test *testing.T) {
+			requireVcs(test, setup.bin)
+			testVcs(test, setup)
+		})
+	}
+}
+
+func testVcs(test *testing.T, setup vcsTestSetup) {
+	tmp, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if setup.bin == "bzr" {
+		setup.env = append(setup.env, "BZR_HOME="+tmp)
+	}
+	run := func(dir string, args ...string) {
+		cmd := Command(args[0], args[1:]...).Setenv(setup.env...)
+		cmd.Cmd.Dir = dir
+		err := cmd.DiscardOutput()
+		if err != nil {
+			test.Fatalf("%s: %s", strings.Join(args, " "), err)
+		}
+	}
+	write := func(path, data string) {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(data), 0644)
+		}
+		if err != nil {
+			test.Fatal(err)
+		}
+	}
+	// The env is needed also by Vcs methods (e.g. for `hg tag`).
+	for _, entry := range setup.env {
+		kv := strings.SplitN(entry, "=", 2)
+		defer os.Setenv(kv[0], os.Getenv(kv[0]))
+		os.Setenv(kv[0], kv[1])
+	}
+	vcs := setup.vcs
+
+	// Create repository with 3 commits: first tagged, second plain, third
+	// at tip of the default branch.
+	orig := filepath.Join(tmp, "orig")
+	write(filepath.Join(orig, "sub", "a.txt"), "1")
+	write(filepath.Join(orig, "other", "b.txt"), "1")
+	run(orig, setup.init...)
+	run(orig, setup.add...)
+	run(orig, append(setup.commit, "first")...)
+	rev1, err := vcs.Revision(orig)
+	if err != nil {
+		test.Fatal(err)
+	}
+	write(filepath.Join(orig, "sub", "a.txt"), "2")
+	run(orig, setup.add...)
+	run(orig, append(setup.commit, "second")...)
+	rev2, err := vcs.Revision(orig)
+	if err != nil {
+		test.Fatal(err)
+	}
+	write(filepath.Join(orig, "sub", "a.txt"), "3")
+	run(orig, setup.add...)
+	run(orig, append(setup.commit, "third\n\nwith description")...)
+	// NOTE: `hg tag` commits .hgtags, creating a new tip.
+	run(orig, setup.tag("v1", rev1)...)
+	if rev1 == rev2 || rev1 == "" {
+		test.Fatalf("expected different revisions, got %q and %q", rev1, rev2)
+	}
+
+	clone := filepath.Join(tmp, "clone")
+	err = os.Mkdir(clone, 0755)
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = vcs.Clone(orig, clone)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(clone, vcs.Dir())); err != nil {
+		test.Errorf("expected %s in clone: %s", vcs.Dir(), err)
+	}
+	tip, err := vcs.Revision(clone)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expectRef := func(expected string) {
+		ref, err := vcs.HeadSymbolicRef(clone)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if ref != expected {
+			test.Errorf("expected HeadSymbolicRef %q, got %q", expected, ref)
+		}
+	}
+	expectRevision := func(expected string) {
+		revision, err := vcs.Revision(clone)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if revision != expected {
+			test.Errorf("expected revision %q, got %q", expected, revision)
+		}
+	}
+	checkout := func(revision string) {
+		err := vcs.Checkout(clone, revision)
+		if err != nil {
+			test.Fatalf("checkout %s: %s", revision, err)
+		}
+	}
+
+	// Revision info.
+	revisionTime, err := vcs.RevisionTime(clone)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if t, err := time.Parse(time.RFC3339, revisionTime); err != nil || time.Since(t) > time.Hour {
+		test.Errorf("unexpected revision time %q, %v", revisionTime, err)
+	}
+
+	// Symbolic refs, and returning to them.
+	branchRef := setup.branchRef(clone)
+	expectRef(branchRef)
+	checkout(rev2)
+	expectRevision(rev2)
+	expectRef(rev2)
+	if subject, err := vcs.RevisionSubject(clone); err != nil || subject != "second" {
+		test.Errorf("expected subject \"second\" after checkout, got %q, %v", subject, err)
+	}
+	checkout(rev1)
+	expectRevision(rev1)
+	expectRef(setup.tagRef("v1"))
+	checkout(branchRef)
+	expectRevision(tip)
+	checkout(setup.tagRef("v1"))
+	expectRevision(rev1)
+	if setup.bookmark != nil {
+		run(clone, setup.bookmark("feature", rev2)...)
+		checkout("feature")
+		expectRevision(rev2)
+		expectRef("feature")
+	}
+	checkout(branchRef)
+	expectRevision(tip)
+	expectRef(branchRef)
+
+	// Cleanliness, limited to subpath. Only files in keptFiles are expected on
+	// disk in a pruned repository.
+	keptFiles := set{}
+	kept := func(file string) bool {
+		_, found := keptFiles[file]
+		return found
+	}
+	expectClean := func(note, subpath string, expected, expectedPruned bool) {
+		clean, err := vcs.IsClean(clone, subpath)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if clean != expected {
+			test.Errorf("%s: expected IsClean(%q)=%v, got %v", note, subpath, expected, clean)
+		}
+		clean, err = vcs.IsCleanPruned(clone, subpath, kept)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if clean != expectedPruned {
+			test.Errorf("%s: expected IsCleanPruned(%q)=%v, got %v", note, subpath, expectedPruned, clean)
+		}
+	}
+	expectClean("clean", ".", true, true)
+	aPath := filepath.Join(clone, "sub", "a.txt")
+	write(aPath, "modified")
+	expectClean("modified", ".", false, false)
+	expectClean("modified", "sub", false, false)
+	expectClean("modified", "other", true, true)
+	write(aPath, "3")
+	write(filepath.Join(clone, "sub", "new", "c.txt"), "new")
+	expectClean("untracked", "sub", false, false)
+	expectClean("untracked", "other", true, true)
+	os.RemoveAll(filepath.Join(clone, "sub", "new"))
+	os.Remove(aPath)
+	expectClean("missing", "sub", false, true)
+	expectClean("missing", "other", true, true)
+	keptFiles.Add("sub/a.txt")
+	expectClean("missing kept", "sub", false, false)
+	expectClean("missing kept", "other", true, true)
+	write(aPath, "3")
+
+	// Origin, and fetching from a fork with a new commit.
+	// NOTE: bzr reports parent location as a file:// URL.
+	expectOrigin := func(expected string) {
+		origin, err := vcs.Origin(clone)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !strings.HasSuffix(strings.TrimSuffix(filepath.ToSlash(origin), "/"), "/"+expected) {
+			test.Errorf("expected Origin ending with %q, got %q", expected, origin)
+		}
+	}
+	expectOrigin("orig")
+	fork := filepath.Join(tmp, "fork")
+	err = os.Mkdir(fork, 0755)
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = vcs.Clone(orig, fork)
+	if err != nil {
+		test.Fatal(err)
+	}
+	write(filepath.Join(fork, "sub", "a.txt"), "4")
+	run(fork, setup.add...)
+	run(fork, append(setup.commit, "forked")...)
+	forkTip, err := vcs.Revision(fork)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fetched, err := vcs.Fetch(clone, fork, "")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fetched != forkTip {
+		test.Errorf("expected Fetch to return fork's tip %q, got %q", forkTip, fetched)
+	}
+	expectOrigin("fork")
+	checkout(fetched)
+	expectRevision(forkTip)
+	fetched, err = vcs.Fetch(clone, fork, setup.tagRef("v1"))
+	if err != nil || fetched != rev1 {
+		test.Errorf("expected Fetch of tag to return %q, got %q, %v", rev1, fetched, err)
+	}
+}
+
+func Test_setIniValue(test *testing.T) {
+	cases := []struct {
+		text, expected string
+	}{
+		{"", "[paths]\ndefault = NEW\n"},
+		{"[ui]\nusername = x", "[ui]\nusername = x\n[paths]\ndefault = NEW\n"},
+		{"[paths]\ndefault = /old\nother = /x\n", "[paths]\ndefault = NEW\nother = /x\n"},
+		{"[paths]\nother = /x\n\n[ui]\n", "[paths]\nother = /x\ndefault = NEW\n\n[ui]\n"},
+		{"[ui]\ndefault = x\n[paths]\n", "[ui]\ndefault = x\n[paths]\ndefault = NEW\n"},
+	}
+	for _, c := range cases {
+		result := setIniValue(c.text, "paths", "default", "NEW")
+		if result != c.expected {
+			test.Errorf("setIniValue(%q): expected %q, got %q", c.text, c.expected, result)
+		}
+	}
+}
+
+func Test_VcsList_gitFiles(test *testing.T) {
+	requireVcs(test, "git")
+	tmp, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	env := append(vcsTestSetups[0].env, "GIT_ALLOW_PROTOCOL=file")
+	run := func(dir string, args ...string) {
+		cmd := Command("git", args...).Setenv(env...)
+		cmd.Cmd.Dir = dir
+		err := cmd.DiscardOutput()
+		if err != nil {
+			test.Fatalf("git %s: %s", strings.Join(args, " "), err)
+		}
+	}
+	for _, dir := range []string{"main/pkg", "super"} {
+		err = os.MkdirAll(filepath.Join(tmp, dir), 0755)
+		if err != nil {
+			test.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(tmp, "main", "pkg", "a.go"), []byte("package pkg\n"), 0644)
+	if err != nil {
+		test.Fatal(err)
+	}
+	main := filepath.Join(tmp, "main")
+	run(main, "init", "-q")
+	run(main, "add", "-A")
+	run(main, "commit", "-q", "-m", "first")
+	run(main, "worktree", "add", "-q", "--detach", filepath.Join(tmp, "wt"))
+	super := filepath.Join(tmp, "super")
+	run(super, "init", "-q")
+	run(super, "submodule", "add", "-q", main, "sub")
+
+	cases := []struct {
+		path, root, vendoredRoot string
+	}{
+		{"main/pkg", "main", "main"},
+		{"wt/pkg", "wt", "wt"},
+		{"super/sub/pkg", "super/sub", "super"},
+	}
+	for _, c := range cases {
+		path := filepath.Join(tmp, filepath.FromSlash(c.path))
+		root, vcs, err := vcsList.FindRoot(path)
+		if err != nil || vcs == nil || root != filepath.Join(tmp, filepath.FromSlash(c.root)) {
+			test.Errorf("FindRoot(%q): expected %q, got %q, %v, %v", c.path, c.root, root, vcs, err)
+		}
+		root, vcs, err = vcsList.FindVendoredRoot(path)
+		if err != nil || vcs == nil || root != filepath.Join(tmp, filepath.FromSlash(c.vendoredRoot)) {
+			test.Errorf("FindVendoredRoot(%q): expected %q, got %q, %v, %v", c.path, c.vendoredRoot, root, vcs, err)
+		}
+		if err := (Exist{}.GitDir(filepath.Join(tmp, filepath.FromSlash(c.root), ".git"))).Err; err != nil {
+			test.Errorf("Exist.GitDir for %q: %s", c.root, err)
+		}
+	}
+
+	// Broken ".git" file is not a repository.
+	broken := filepath.Join(tmp, "broken")
+	err = os.Mkdir(broken, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(broken, ".git"), []byte("gitdir: ../nonexistent\n"), 0644)
+	}
+	if err != nil {
+		test.Fatal(err)
+	}
+	if vcs, err := vcsList.IsRoot(broken); vcs != nil || err != nil {
+		test.Errorf("expected broken .git file not to be detected, got %v, %v", vcs, err)
+	}
+	if (Exist{}.GitDir(filepath.Join(broken, ".git"))).Err == nil {
+		test.Errorf("expected Exist.GitDir error for broken .git file")
+	}
+}
@@ -13,8 +13,10 @@ func main() {
 	err := run()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+		Exit(1)
 	}
+	// NOTE: don't just return, so that any cleanups after a signal can finish.
+	Exit(0)
 }
 
 var cmds = &cobra.Command{
@@ -23,7 +25,13 @@ var cmds = &cobra.Command{
 }
 
 func run() error {
-	// FIXME(mateuszc): make -v work also for any places where we use raw exec.Command
+	// NOTE: all external commands must be run via Cmd, so that they're shown with -v, and recorded with --trace.
 	cmds.Flags().BoolVar(&Verbose, "v", false, "show all executed commands")
+	cmds.PersistentFlags().StringVar(&TracePath, "trace", "", "write a JSON trace of all executed commands to this file, and print a summary of time spent in each phase")
+	cmds.PersistentFlags().DurationVar(&DefaultTimeout, "timeout", 0, "kill any external command running longer than this (e.g. 10m); 0 means no limit")
+	cmds.PersistentFlags().StringVar(&MirrorDir, "mirror-dir", "", "directory with local mirrors of upstream repositories (default: $VENDO_MIRROR_DIR, or vendo/mirrors in user's cache directory)")
+	handleSignals()
+	// Commands run in their own process groups, so they can't prompt on the terminal (see setProcessGroup).
+	disableTerminalPrompts()
 	return cmds.Execute()
 }
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path"
 	"strings"
@@ -29,6 +28,33 @@ type VendorFile struct {
 	// changes that are not backwards compatible, so leave this as def876."
 	Comment string `json:"comment,omitempty"`
 
+	// NoTestDeps is true if the packages were crawled without dependencies
+	// of the project's tests (e.g. for a release snapshot).
+	//
+	// NoTestDeps is a custom field, specific to the "vendo" tool.
+	NoTestDeps bool `json:"noTestDeps,omitempty"`
+
+	// AllowedLicenses is the project's license policy: a list of SPDX license
+	// identifiers (e.g. "MIT", "BSD-3-Clause") allowed for vendored
+	// repositories. If empty, licenses are not checked.
+	//
+	// AllowedLicenses is a custom field, specific to the "vendo" tool.
+	AllowedLicenses []string `json:"allowedLicenses,omitempty"`
+
+	// Prune, if not nil, means that only files needed to build the vendored
+	// packages (plus license files) were added to the project from each
+	// repository root. See PruneConfig.
+	//
+	// Prune is a custom field, specific to the "vendo" tool.
+	Prune *PruneConfig `json:"prune,omitempty"`
+
+	// VendorSubmodules is true if contents of git submodules of vendored
+	// repositories were added to the project as plain files. If false,
+	// repositories with submodules are reported as error.
+	//
+	// VendorSubmodules is a custom field, specific to the "vendo" tool.
+	VendorSubmodules bool `json:"vendorSubmodules,omitempty"`
+
 	// Packages represents a collection of vendor packages that have been copied
 	// locally. Each entry represents a single Go package.
 	Packages []*VendorPackage `json:"package"`
@@ -68,6 +94,100 @@ type VendorPackage struct {
 	// always use forward slashes and must not contain the path elements "."
 	// or "..".
 	RepositoryRoot string `json:"repositoryRoot"`
+
+	// Vcs is the version control system of the repository: "git", "hg" or
+	// "bzr".
+	//
+	// Vcs is custom field, specific for "vendo" tool.
+	Vcs string `json:"vcs,omitempty"`
+
+	// Origin is the URL (or local path) of the repository from which
+	// RepositoryRoot was cloned, and from which 'vendo update' fetches
+	// newer revisions. If Source is set, Origin keeps the upstream
+	// repository.
+	//
+	// Origin is custom field, specific for "vendo" tool.
+	Origin string `json:"origin,omitempty"`
+
+	// Source, if set, overrides where the repository is vendored from: URL
+	// or local path of e.g. a fork, used instead of GOPATH by 'recreate' and
+	// instead of Origin by 'update', while Canonical keeps the upstream import
+	// path. It's set by hand, for any of the repository's packages.
+	//
+	// Source is custom field, specific for "vendo" tool.
+	Source string `json:"source,omitempty"`
+
+	// Scope describes why the package is needed by the project: by
+	// production code ("build"), directly by project's tests ("test"), or
+	// only indirectly by project's tests ("transitive-test"). Empty value
+	// means the scope was not recorded (e.g. vendor.json was created by an
+	// older version of the tool).
+	//
+	// Scope is custom field, specific for "vendo" tool.
+	Scope Scope `json:"scope,omitempty"`
+
+	// Platforms lists GOOS & GOARCH pairs (from VendorFile's Platforms) on
+	// which the package is needed. Empty value means the platforms were not
+	// recorded (e.g. vendor.json was created by an older version of the tool).
+	//
+	// Platforms is custom field, specific for "vendo" tool.
+	Platforms []Platform `json:"platforms,omitempty"`
+
+	// License is an SPDX license expression detected from license files in
+	// RepositoryRoot, e.g. "MIT" or "Apache-2.0 AND MIT", or "unknown".
+	//
+	// License is custom field, specific for "vendo" tool.
+	License string `json:"license,omitempty"`
+
+	// LicenseFiles lists names of license and notice files found in
+	// RepositoryRoot (e.g. "LICENSE", "NOTICE").
+	//
+	// LicenseFiles is custom field, specific for "vendo" tool.
+	LicenseFiles []string `json:"licenseFiles,omitempty"`
+
+	// Pruned is true if only some of the files in RepositoryRoot were added to
+	// the project (see VendorFile's Prune). Files of the upstream repository
+	// which are missing in the project are then expected, and not treated as
+	// local patches.
+	//
+	// Pruned is custom field, specific for "vendo" tool.
+	Pruned bool `json:"pruned,omitempty"`
+
+	// Submodules lists git submodules of the repository (recursively), whose
+	// contents were vendored as plain files (see VendorFile's
+	// VendorSubmodules).
+	//
+	// Submodules is custom field, specific for "vendo" tool.
+	Submodules []Submodule `json:"submodules,omitempty"`
+}
+
+type Submodule struct {
+	// Path of the submodule, relative to RepositoryRoot, slash-separated.
+	Path     string `json:"path"`
+	Revision string `json:"revision"`
+}
+
+type Scope string
+
+const (
+	ScopeBuild          Scope = "build"
+	ScopeTest           Scope = "test"
+	ScopeTransitiveTest Scope = "transitive-test"
+)
+
+// PruneConfig lists patterns of files to keep or drop when pruning vendored
+// repositories. By default, only files directly in directories of vendored
+// packages, and license files in repository root, are kept. Patterns are
+// matched with path.Match against paths relative to repository root: patterns
+// containing "/" against the path and its parent directories, other patterns
+// against each path element. Keep patterns take precedence over Drop.
+type PruneConfig struct {
+	// Keep lists patterns of additional files to keep, e.g. "*.h" or
+	// "internal/asm".
+	Keep []string `json:"keep,omitempty"`
+	// Drop lists patterns of files to remove even from directories of vendored
+	// packages, e.g. "*_test.go" or "testdata".
+	Drop []string `json:"drop,omitempty"`
 }
 
 type Platform struct {
@@ -75,8 +195,11 @@ type Platform struct {
 	Arch string `json:"arch"`
 }
 
+func (p Platform) String() string {
+	return p.Os + "_" + p.Arch
+}
 func (p Platform) MarshalJSON() ([]byte, error) {
-	return json.Marshal(p.Os + "_" + p.Arch)
+	return json.Marshal(p.String())
 }
 func (p *Platform) UnmarshalJSON(data []byte) error {
 	s := ""
@@ -143,7 +266,7 @@ func (v *VendorFile) WriteTo(path string) error {
 		// TODO(mateuszc): add more context to error message?
 		return err
 	}
-	err = ioutil.WriteFile(path, buf, 0644)
+	err = writeFile(path, buf)
 	if err != nil {
 		// TODO(mateuszc): add more context to error message?
 		return err
@@ -195,3 +318,21 @@ type PackagesOrder []*VendorPackage
 func (p PackagesOrder) Len() int           { return len(p) }
 func (p PackagesOrder) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p PackagesOrder) Less(i, j int) bool { return p[i].Canonical < p[j].Canonical }
+
+// PlatformsNote returns a short note for humans, like "only on windows_amd64",
+// if pkg is needed on just some of the platforms listed in v. Otherwise,
+// returns empty string.
+func (v *VendorFile) PlatformsNote(pkg *VendorPackage) string {
+	if len(pkg.Platforms) == 0 || len(pkg.Platforms) >= len(v.Platforms) {
+		return ""
+	}
+	return "only on " + formatPlatforms(pkg.Platforms)
+}
+
+func formatPlatforms(platforms []Platform) string {
+	names := []string{}
+	for _, p := range platforms {
+		names = append(names, p.String())
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "verify-build",
+		Short: "build the project using only packages from " + VendorPath + "/",
+		Long: fmt.Sprintf(
+			`Verify-build builds all packages of the project in an isolated GOPATH,
+containing only the project and %[1]s/, for each of the platforms listed in
+%[2]s (cross-compiling with GOOS and GOARCH). It also reports any
+dependencies resolved from outside %[1]s/ (other than standard library).
+
+With --test, tests are built too, and run on the current platform.`,
+			VendorPath, JsonPath),
+	}
+	test := cmd.Flags().Bool("test", false, "build tests too, and run them on the current platform")
+	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		return VerifyBuild(*test)
+	})
+	cmds.AddCommand(cmd)
+}
+
+func VerifyBuild(test bool) error {
+	// Make sure we're in project's root dir (with vendor.json and _vendor/)
+	exist := Exist{}.File(JsonPath).Dir(VendorPath)
+	if exist.Err != nil {
+		return exist.Err
+	}
+	pkgs, err := ReadVendorFile(JsonPath)
+	if err != nil {
+		return err
+	}
+	if len(pkgs.Platforms) == 0 {
+		return fmt.Errorf(`empty or missing "platforms" in %s`, JsonPath)
+	}
+	importPath, err := findProjectImportPath()
+	if err != nil {
+		return err
+	}
+	projectDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	vendorAbsPath, err := getVendorAbsPath()
+	if err != nil {
+		return err
+	}
+
+	// Prepare isolated GOPATH: a symlink to the project, plus _vendor/.
+	tmp, err := ioutil.TempDir("", "vendo-build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	defer AddCleanup(func() { os.RemoveAll(tmp) })()
+	linkDir := filepath.Join(tmp, "src", filepath.FromSlash(importPath))
+	err = os.MkdirAll(filepath.Dir(linkDir), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Symlink(projectDir, linkDir)
+	if err != nil {
+		return err
+	}
+	env := []string{
+		"GOPATH=" + tmp + string(filepath.ListSeparator) + vendorAbsPath,
+		// Go tools use $PWD to find the current dir, so the symlink is not
+		// resolved to original location of the project.
+		"PWD=" + linkDir,
+		// Build in GOPATH mode (default is module mode since Go 1.16), and
+		// ignore user's go env config file and default flags.
+		"GO111MODULE=off",
+		"GOFLAGS=",
+		"GOENV=off",
+	}
+
+	failed := []string{}
+	for _, platform := range pkgs.Platforms {
+		platformEnv := append([]string{"GOOS=" + platform.Os, "GOARCH=" + platform.Arch}, env...)
+		fmt.Fprintf(os.Stderr, "# %s\n", platform)
+		endPhase := Phase("build " + platform.String())
+
+		ok := true
+		outside, err := findDepsOutsideVendor(linkDir, vendorAbsPath, platformEnv)
+		if err != nil {
+			endPhase()
+			return err
+		}
+		for _, dep := range outside {
+			fmt.Fprintf(os.Stderr, "%s: package resolved from outside %s/: %s\n", platform, VendorPath, dep)
+			ok = false
+		}
+
+		// NOTE: if ./... matches a single "main" package, 'go build' would write an executable to project's dir.
+		args := []string{"build", "-o", filepath.Join(tmp, "bin") + string(filepath.Separator), "./..."}
+		if test && platform.Os == runtime.GOOS && platform.Arch == runtime.GOARCH {
+			args = []string{"test", "./..."}
+		}
+		cmd := Command("go", args...).LogAlways().Setenv(platformEnv...)
+		cmd.Cmd.Dir = linkDir
+		if cmd.DiscardOutput() != nil {
+			ok = false
+		}
+		if test && (platform.Os != runtime.GOOS || platform.Arch != runtime.GOARCH) {
+			// Cannot run tests for other platforms, only build them.
+			built, err := buildTests(linkDir, filepath.Join(tmp, "tests"), platformEnv)
+			if err != nil {
+				endPhase()
+				return err
+			}
+			ok = ok && built
+		}
+		endPhase()
+		if !ok {
+			failed = append(failed, platform.String())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("build verification failed for platforms: %s", strings.Join(failed, " "))
+	}
+	return nil
+}
+
+// buildTests builds (but doesn't run) test binaries of all packages in dir,
+// writing them to outDir. It returns false if any of the builds failed.
+//
+// NOTE: `go test -c` accepts only one package in older Go versions.
+func buildTests(dir, outDir string, env []string) (bool, error) {
+	cmd := GoList("{{.ImportPath}}", "./...").Setenv(env...)
+	cmd.Cmd.Dir = dir
+	packages, err := cmd.OutputLines()
+	if err != nil {
+		return false, err
+	}
+	ok := true
+	for i, pkg := range packages {
+		cmd := Command("go", "test", "-c", "-o", filepath.Join(outDir, fmt.Sprintf("%d.test", i)), pkg).
+			LogAlways().
+			Setenv(env...)
+		cmd.Cmd.Dir = dir
+		if cmd.DiscardOutput() != nil {
+			ok = false
+		}
+	}
+	return ok, nil
+}
+
+// findDepsOutsideVendor returns dependencies of packages in dir (including
+// tests), which are not from standard library, are not in the project, and
+// are not found in vendorAbsPath. Each is returned with its directory, or
+// error.
+func findDepsOutsideVendor(dir, vendorAbsPath string, env []string) ([]string, error) {
+	cmd := GoList(`{{join .Deps "\n"}}{{"\n"}}{{join .TestImports "\n"}}{{"\n"}}{{join .XTestImports "\n"}}`, "./...").
+		WithFailed().
+		Setenv(env...)
+	cmd.Cmd.Dir = dir
+	lines, err := cmd.OutputLines()
+	if err != nil {
+		return nil, err
+	}
+	deps := set{}
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" && line != "C" {
+			deps.Add(line)
+		}
+	}
+	if len(deps) == 0 {
+		return nil, nil
+	}
+	imports := deps.ToSlice()
+	sort.Strings(imports)
+
+	cmd = GoList("{{.ImportPath}}\t{{.Standard}}\t{{.Dir}}", imports...).
+		WithFailed().
+		Setenv(env...)
+	cmd.Cmd.Dir = dir
+	lines, err = cmd.OutputLines()
+	if err != nil {
+		return nil, err
+	}
+	outside := []string{}
+	for _, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected format of go list output: %q", line)
+		}
+		imp, standard, pkgDir := fields[0], fields[1], filepath.ToSlash(fields[2])
+		switch {
+		case standard == "true":
+		case pkgDir == "":
+			outside = append(outside, imp+" (not found)")
+		case isSubdir(pkgDir, filepath.ToSlash(vendorAbsPath)):
+		case isProjectPackageDir(pkgDir, filepath.ToSlash(dir)):
+		default:
+			outside = append(outside, imp+" ("+pkgDir+")")
+		}
+	}
+	return outside, nil
+}
+
+// isProjectPackageDir returns true if slash-separated pkgDir is project's own
+// package, i.e. is in projectDir, but not in a "vendor/" subdir (used by Go
+// 1.5+ "vendor experiment").
+func isProjectPackageDir(pkgDir, projectDir string) bool {
+	if pkgDir != projectDir && !isSubdir(pkgDir, projectDir) {
+		return false
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(pkgDir, projectDir), "/") {
+		if name == "vendor" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func Test_isProjectPackageDir(test *testing.T) {
+	project := "/tmp/gopath/src/example.com/proj"
+	cases := map[string]bool{
+		project:                                        true,
+		project + "/sub/pkg":                           true,
+		project + "/vendor/github.com/foo/bar":         false,
+		project + "/sub/vendor/github.com/foo/bar":     false,
+		project + "/vendoring":                         true,
+		"/tmp/gopath/src/example.com/proj2":            false,
+		"/tmp/gopath/src/example.com/proj/_vendor/src": true,
+	}
+	for dir, expected := range cases {
+		if got := isProjectPackageDir(dir, project); got != expected {
+			test.Errorf("isProjectPackageDir(%q): expected %v, got %v", dir, expected, got)
+		}
+	}
+}
+
+// Test_VerifyBuild builds a tiny project against its _vendor/, for the
+// current platform and cross-compiled for another one.
+func Test_VerifyBuild(test *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		test.Skip("go not installed")
+	}
+	gopath, err := ioutil.TempDir("", "vendo-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	project := filepath.Join(gopath, "src", "example.com", "proj")
+	other := "windows_amd64"
+	if runtime.GOOS == "windows" {
+		other = "linux_amd64"
+	}
+	writeTree(test, project, map[string]string{
+		"main.go":                            "package main\n\nimport \"example.com/dep\"\n\nfunc main() { dep.Hello() }\n",
+		"main_test.go":                       "package main\n\nimport \"testing\"\n\nfunc TestMain(t *testing.T) { main() }\n",
+		"vendor.json":                        `{"platforms": ["` + runtime.GOOS + "_" + runtime.GOARCH + `", "` + other + `"], "package": []}`,
+		"_vendor/src/example.com/dep/dep.go": "package dep\n\nfunc Hello() {}\n",
+	})
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = os.Chdir(project)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	// NOTE: the project's import path is found in GOPATH.
+	for _, kv := range [][2]string{{"GOPATH", gopath}, {"GO111MODULE", "off"}} {
+		defer os.Setenv(kv[0], os.Getenv(kv[0]))
+		os.Setenv(kv[0], kv[1])
+	}
+
+	err = VerifyBuild(true)
+	if err != nil {
+		test.Errorf("expected successful build, got: %s", err)
+	}
+
+	// A dependency found only in GOPATH, outside _vendor/, is not used.
+	writeTree(test, gopath, map[string]string{
+		"src/example.com/dep/dep.go": "package dep\n\nfunc Hello() {}\n",
+	})
+	err = os.RemoveAll(filepath.Join(project, "_vendor", "src"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = VerifyBuild(false)
+	if err == nil {
+		test.Errorf("expected build failure without example.com/dep in %s/", VendorPath)
+	}
+}
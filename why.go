@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:     "why IMPORT_PATH",
+		Short:   "explain why a package is vendored",
+		Example: "  vendo why github.com/inconshreveable/mousetrap",
+		Long: fmt.Sprintf(
+			`Why crawls the dependencies of current project the same way as 'recreate',
+and prints the shortest chains of imports leading from the project's packages
+to the specified package, for each platform where the package is needed.
+Each step lists the file and line of the import statement.
+
+By default, platforms are read from %s.`,
+			JsonPath),
+	}
+	var (
+		all           = cmd.Flags().Bool("all", false, "show every import chain, not only the shortest ones")
+		platformsList = cmd.Flags().String("platforms", "", "format: OS_ARCH,OS_ARCH2[,...]")
+	)
+	cmd.Run = wrapRun(func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			// TODO(mateuszc): subcmd usage
+			return fmt.Errorf("subcommand 'why' requires argument specifying package import path")
+		}
+		platforms, err := parsePlatforms(*platformsList)
+		if err != nil {
+			// TODO(mateuszc): subcmd usage
+			return err
+		}
+		return Why(args[0], platforms, *all)
+	})
+	cmds.AddCommand(cmd)
+}
+
+func Why(target string, platforms []Platform, all bool) error {
+	if len(platforms) == 0 {
+		pkgs, err := ReadVendorFile(JsonPath)
+		if err != nil {
+			return err
+		}
+		platforms = pkgs.Platforms
+		if len(platforms) == 0 {
+			return fmt.Errorf(`empty list of platforms (you must set flag "-platforms" or %s field "platforms")`, JsonPath)
+		}
+	}
+
+	// Use the same GOPATH as 'recreate': GOPATH=$PWD/_vendor:$GOPATH
+	vendorAbsPath, err := getVendorAbsPath()
+	if err != nil {
+		return err
+	}
+	gopath := vendorAbsPath + string(filepath.ListSeparator) + os.Getenv("GOPATH")
+	deps, err := crawlDependenciesCached(gopath, platforms)
+	if err != nil {
+		return err
+	}
+
+	// Render chains for each platform, then merge platforms with identical results.
+	outputs := []string{}
+	platformsByOutput := map[string][]Platform{}
+	for _, g := range deps.Graphs {
+		chains := g.ImportChains(target, all)
+		if len(chains) == 0 {
+			continue
+		}
+		output := formatImportChains(chains)
+		if platformsByOutput[output] == nil {
+			outputs = append(outputs, output)
+		}
+		platformsByOutput[output] = append(platformsByOutput[output], g.Platform)
+	}
+	if len(outputs) == 0 {
+		return fmt.Errorf("package %s is not needed by the project on any of platforms: %s",
+			target, formatPlatforms(platforms))
+	}
+	for i, output := range outputs {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("# %s\n%s", formatPlatforms(platformsByOutput[output]), output)
+	}
+	return nil
+}
+
+// ImportChain is a sequence of imports leading from a project package to
+// some dependency.
+type ImportChain struct {
+	// From is the import path of the project package.
+	From string
+	// Imports are the subsequent import statements: first one is in package
+	// From, each next one is in the package imported by the previous one.
+	Imports []Import
+	// Test is true if the first import is in a "*_test.go" file.
+	Test bool
+}
+
+// ImportChains returns chains of imports leading from project packages to
+// target. If all is false, only the shortest chains are returned. Chains never
+// visit the same package twice.
+func (g *Graph) ImportChains(target string, all bool) []ImportChain {
+	if g.Packages[target] == nil {
+		return nil
+	}
+
+	// Find the shortest distance to target from each package which can reach
+	// it, by walking the graph backwards.
+	importers := map[string][]string{}
+	for _, pkg := range g.Packages {
+		for _, imports := range [][]Import{pkg.Imports, pkg.TestImports} {
+			for _, imp := range imports {
+				importers[imp.Path] = append(importers[imp.Path], pkg.ImportPath)
+			}
+		}
+	}
+	distance := map[string]int{target: 0}
+	queue := []string{target}
+	for len(queue) > 0 {
+		imp := queue[0]
+		queue = queue[1:]
+		for _, importer := range importers[imp] {
+			if _, found := distance[importer]; !found {
+				distance[importer] = distance[imp] + 1
+				queue = append(queue, importer)
+			}
+		}
+	}
+
+	shortest := -1
+	for _, pkg := range g.projectPackages() {
+		d, found := distance[pkg.ImportPath]
+		if found && (shortest == -1 || d < shortest) {
+			shortest = d
+		}
+	}
+	if shortest == -1 {
+		return nil
+	}
+
+	chains := []ImportChain{}
+	onPath := map[string]bool{}
+	var walk func(chain ImportChain, pkg string)
+	walk = func(chain ImportChain, pkg string) {
+		if pkg == target {
+			chain.Imports = append([]Import{}, chain.Imports...)
+			chains = append(chains, chain)
+			return
+		}
+		onPath[pkg] = true
+		defer delete(onPath, pkg)
+		imports := g.Packages[pkg].Imports
+		if len(chain.Imports) == 0 {
+			imports = append(append([]Import{}, imports...), g.Packages[pkg].TestImports...)
+		}
+		seen := set{}
+		for i, imp := range imports {
+			d, found := distance[imp.Path]
+			if !found || onPath[imp.Path] || (!all && d != distance[pkg]-1) {
+				continue
+			}
+			// Show only the first import of a package from each importer.
+			if _, dup := seen[imp.Path]; dup {
+				continue
+			}
+			seen.Add(imp.Path)
+			next := chain
+			if len(chain.Imports) == 0 {
+				next.Test = i >= len(g.Packages[pkg].Imports)
+			}
+			next.Imports = append(chain.Imports[:len(chain.Imports):len(chain.Imports)], imp)
+			walk(next, imp.Path)
+		}
+	}
+	for _, pkg := range g.projectPackages() {
+		d, found := distance[pkg.ImportPath]
+		if !found || (!all && d != shortest) {
+			continue
+		}
+		walk(ImportChain{From: pkg.ImportPath}, pkg.ImportPath)
+	}
+	return chains
+}
+
+func formatImportChains(chains []ImportChain) string {
+	cwd, _ := os.Getwd()
+	lines := []string{}
+	for i, chain := range chains {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		importer := chain.From
+		for j, imp := range chain.Imports {
+			file := imp.File
+			if rel, err := filepath.Rel(cwd, filepath.FromSlash(file)); err == nil && filepath.IsAbs(file) && !strings.HasPrefix(rel, "..") {
+				file = filepath.ToSlash(rel)
+			}
+			note := ""
+			if j == 0 && chain.Test {
+				note = " (test)"
+			}
+			lines = append(lines, importer, fmt.Sprintf("\t%s:%d%s", file, imp.Line, note))
+			importer = imp.Path
+		}
+		lines = append(lines, importer)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
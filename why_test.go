@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Graph_ImportChains(test *testing.T) {
+	// proj -> a -> c -> target
+	// proj -> b -> target
+	// proj (test) -> target
+	g := &Graph{Packages: map[string]*GraphPackage{
+		"proj": {ImportPath: "proj", Project: true,
+			Imports: []Import{
+				{Path: "a", File: "proj.go", Line: 3},
+				{Path: "b", File: "proj.go", Line: 4},
+			},
+			TestImports: []Import{{Path: "target", File: "proj_test.go", Line: 3}},
+		},
+		"a":      {ImportPath: "a", Imports: []Import{{Path: "c"}}},
+		"b":      {ImportPath: "b", Imports: []Import{{Path: "target"}, {Path: "target"}}},
+		"c":      {ImportPath: "c", Imports: []Import{{Path: "target"}, {Path: "a"}}},
+		"target": {ImportPath: "target"},
+	}}
+
+	cases := []struct {
+		all      bool
+		expected []string
+	}{
+		{false, []string{"proj target (test)"}},
+		{true, []string{"proj a c target", "proj b target", "proj target (test)"}},
+	}
+	for _, c := range cases {
+		result := []string{}
+		for _, chain := range g.ImportChains("target", c.all) {
+			steps := []string{chain.From}
+			for _, imp := range chain.Imports {
+				steps = append(steps, imp.Path)
+			}
+			if chain.Test {
+				steps = append(steps, "(test)")
+			}
+			result = append(result, strings.Join(steps, " "))
+		}
+		if !reflect.DeepEqual(result, c.expected) {
+			test.Errorf("all=%v expected:\n%q\ngot:\n%q", c.all, c.expected, result)
+		}
+	}
+
+	if chains := g.ImportChains("notfound", true); chains != nil {
+		test.Errorf("expected no chains for missing package, got: %v", chains)
+	}
+}